@@ -10,21 +10,44 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/handlers"
 	"golang.org/x/net/context"
+	stdcontext "context"
 	"github.com/cloudbees/lancelot/proxy"
-	"regexp"
-	"bufio"
-	"github.com/pkg/errors"
+	"github.com/cloudbees/lancelot/proxy/fake"
 	"github.com/docker/docker/pkg/term"
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/cli/cli"
 	"github.com/docker/cli/cli/command"
 	c "github.com/docker/cli/cli/command/container"
 	"github.com/docker/cli/cli/flags"
 	"net"
+	"flag"
+	"strings"
+	"io/ioutil"
+)
+
+// lancelot options, to be set before the `docker run` arguments for first sidecar container
+var (
+	options = flag.NewFlagSet("lancelot", flag.ExitOnError)
+	policyFile = options.String("policy", "", "JSON file defining restrictions policy")
+	cgroupParent = options.String("parent-cgroup", "", "Cgroup to place sidecars in, as a path (cgroupfs) or slice name (systemd). Detected by default")
+	auditFile = options.String("audit", "", "File to write audit trail to, as JSON lines. Default to standard output")
+	podMode = options.Bool("pod", true, "Run sidecars inside Kubernetes pod sandbox, when running in a pod")
+	minAPIVersion = options.String("min-api-version", proxy.DefaultMinAPIVersion, "Oldest API version accepted from clients")
+	exitWithParent = options.Bool("exit-with-parent", false, "Exit when parent or root container dies")
+	tenant = options.String("tenant", "", "Tenant name, prefixing container and volume names so tenants sharing a host don't collide. Default to Lancelot container hostname")
+	namespaceNames = options.Bool("namespace-names", true, "Namespace container and volume names by tenant")
+	hostRoot = options.String("host-root", "", "Where host filesystem is mounted in Lancelot container, required for bind mounts allowed by policy")
+	recordFile = options.String("record", "", "File to record API exchanges to, as JSON lines")
+	idleTimeout = options.Duration("stream-idle-timeout", proxy.DefaultIdleTimeout, "Close attached streams without any traffic for this long, 0 to disable")
 )
 
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
 	fmt.Println(`
 .____                               .__          __
 |    |   _____    ____   ____  ____ |  |   _____/  |_
@@ -34,36 +57,105 @@ func main() {
         \/    \/     \/     \/    \/
         `)
 
-	client, err := client.NewEnvClient()
+	var docker client.APIClient
+	docker, err := client.NewEnvClient()
 	if err != nil {
 		panic(err)
 	}
+	args := parseArgs(os.Args[1:])
+
+	var recorder *proxy.Recorder
+	if *recordFile != "" {
+		f, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		recorder = proxy.NewRecorder(f)
+		docker = recorder.Client(docker)
+	}
+
 	p := &proxy.Proxy{}
-	p.SetClient(client)
+	p.SetMinAPIVersion(*minAPIVersion)
+	p.SetClient(docker)
 
-	cgroup, err := selfContainerId()
-	if err != nil {
+	if *policyFile != "" {
+		policy, err := proxy.LoadPolicy(*policyFile)
+		if err != nil {
+			panic(err)
+		}
+		p.SetPolicy(policy)
+	}
+
+	if *auditFile != "" {
+		f, err := os.OpenFile(*auditFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		p.SetAuditTrail(proxy.NewAuditTrail(f))
+	}
+
+	var cgroup *proxy.Cgroup
+	if *cgroupParent != "" {
+		cgroup = proxy.CgroupOverride(*cgroupParent)
+	} else if cgroup, err = p.DetectCgroup(); err != nil {
 		panic(err)
 	}
 	p.SetCgroup(cgroup)
+	p.SetCgroupLimits(proxy.ReadCgroupLimits("/sys/fs/cgroup"))
+
+	if *podMode {
+		if pod, err := p.DetectPod(); err == nil {
+			p.SetPod(pod)
+		} else {
+			fmt.Println(err.Error())
+		}
+	}
 
 	me, err := selfContainerName()
 	if err != nil {
 		panic(err)
 	}
 	p.SetHostname(me)
+	p.SetHostRoot(*hostRoot)
+	p.SetIdleTimeout(*idleTimeout)
+
+	if *namespaceNames {
+		if *tenant == "" {
+			*tenant = me
+		}
+		if err := p.SetTenant(*tenant); err != nil {
+			panic(err)
+		}
+	}
+
+	// remove leftovers from previous sessions once their retention expired
+	if report := p.Sweep(); len(report.Containers.Removed)+len(report.Volumes.Removed)+len(report.Images.Removed) > 0 {
+		fmt.Println("Removed expired resources from previous sessions:")
+		report.Print(os.Stdout)
+	}
 
 	// subscribe to SIGINT signals
-	stopChan := make(chan os.Signal)
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt)
 
+	// follow parent and root containers, so we can react when they die
+	deaths := p.Watch(context.Background())
+
 	m := mux.NewRouter()
 
 	p.RegisterRoutes(m)
 
-	loggedRouter := handlers.LoggingHandler(os.Stdout, m)
+	var handler http.Handler = m
+	if recorder != nil {
+		handler = recorder.Handler(m)
+	}
+	loggedRouter := handlers.LoggingHandler(os.Stdout, handler)
 
-	srv := &http.Server{Addr: ":2375", Handler: loggedRouter}
+	// requests context is canceled on shutdown, so attached streams get closed
+	base, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{Addr: ":2375", Handler: loggedRouter, BaseContext: func(net.Listener) stdcontext.Context { return base }}
 
 	listener, err := net.Listen("tcp", ":2375")
 	if err != nil {
@@ -71,31 +163,88 @@ func main() {
 	}
 	
 	go srv.Serve(listener)
-	fmt.Println("Lancelot Proxy started\n")
+	fmt.Println("Lancelot Proxy started")
 
 
-	args := os.Args[1:]
+	failed := make(chan error, 1)
 	if len(args) > 0 {
-		if err := runSidecarContainer(args, cgroup, me); err != nil {
-			panic(err)
-		}
+		p.ExpectRoot()
+		go func() {
+			err := runSidecarContainer(args, p.GetCgroup(), me, p.InPod())
+			// 125 is docker run failing before container could run, otherwise container exited and its die event
+			// drives teardown
+			if status, ok := err.(cli.StatusError); ok && status.StatusCode != 125 {
+				fmt.Printf("Root container exited with status %d\n", status.StatusCode)
+			} else if err != nil {
+				failed <- err
+			}
+		}()
 	}
 
-
-	<-stopChan // wait for SIGINT
+	// wait for SIGINT, or parent/root container death
+	for stop := false; !stop; {
+		select {
+		case <-stopChan:
+			stop = true
+		case err := <-failed:
+			fmt.Printf("Failed to run root container: %s\n", err.Error())
+			stop = true
+		case death := <-deaths:
+			fmt.Println(death.String())
+			if *exitWithParent {
+				stop = true
+			} else {
+				fmt.Println("Teardown report:")
+				p.Teardown().Print(os.Stdout)
+			}
+		}
+	}
 
 	// shut down gracefully, but wait no longer than 5 seconds before halting
 	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
 	srv.Shutdown(ctx)
+	cancel()
 
 	p.Stop()
 }
 
+/**
+ * split command line into lancelot options and `docker run` arguments.
+ * lancelot options have to come first, parsing stops on first unknown flag or `--`
+ */
+func parseArgs(args []string) []string {
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			break
+		}
+		value := strings.Contains(name, "=")
+		if value {
+			name = name[:strings.Index(name, "=")]
+		}
+		f := options.Lookup(name)
+		if f == nil {
+			break
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !value && !(ok && b.IsBoolFlag()) {
+			i++ // flag value is next argument
+		}
+	}
+	options.Parse(args[:i])
+	return args[i:]
+}
+
 /**
  * start first sidecar container.
  * lancelot can receive the exact same arguments as a `docker run` command.
  */
-func runSidecarContainer(args []string, cgroup string, lancelot string) error {
+func runSidecarContainer(args []string, cgroup string, lancelot string, pod bool) error {
 
 	// following code is mostly a copy paste from github.com/docker/docker/cmd/docker/docker.go:main()
 	stdin, stdout, stderr := term.StdStreams()
@@ -114,7 +263,12 @@ func runSidecarContainer(args []string, cgroup string, lancelot string) error {
 	cmd := c.NewRunCommand(dockerCli)
 
 	// force new container to run within the same cgroup hierarchy
-	args = append([]string{"--cgroup-parent", cgroup, "--link", lancelot, "--env", "DOCKER_HOST=tcp://"+lancelot+":2375"}, args...)
+	if pod {
+		// sidecar shares pod network, so can reach lancelot on localhost
+		args = append([]string{"--cgroup-parent", cgroup, "--env", "DOCKER_HOST=tcp://localhost:2375"}, args...)
+	} else {
+		args = append([]string{"--cgroup-parent", cgroup, "--link", lancelot, "--env", "DOCKER_HOST=tcp://"+lancelot+":2375"}, args...)
+	}
 
 	fmt.Printf("Starting sidecar container %v\n", args)
 	cmd.SetArgs(args)
@@ -124,24 +278,86 @@ func runSidecarContainer(args []string, cgroup string, lancelot string) error {
 }
 
 /**
- * detect container ID when lancelot is deployed as a docker container
- * which should always be the case but for development
+ * `lancelot replay` re-runs a recording against a policy, and reports requests which aren't handled the same.
+ * Requests are sent to an in-memory fake daemon, unless --docker is set.
  */
-func selfContainerId() (string, error) {
+func replay(args []string) int {
+	flags := flag.NewFlagSet("lancelot replay", flag.ExitOnError)
+	policyFile := flags.String("policy", "", "JSON file defining restrictions policy to replay against")
+	docker := flags.Bool("docker", false, "Replay against docker daemon from environment, not a fake daemon")
+	cgroupParent := flags.String("parent-cgroup", "/lancelot/replay", "Cgroup to place sidecars in")
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for a response, streamed ones being read until then")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: lancelot replay [options] RECORDING")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	exchanges, err := proxy.ReadRecording(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+
+	p := &proxy.Proxy{}
+	if *docker {
+		client, err := client.NewEnvClient()
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		p.SetClient(client)
+	} else {
+		daemon := fake.NewDaemon()
+		// credentials are not recorded, so images are published as public ones
+		for _, i := range proxy.RecordedImages(exchanges) {
+			if err := daemon.Publish(i, ""); err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+		p.SetClient(daemon)
+	}
+	if *policyFile != "" {
+		policy, err := proxy.LoadPolicy(*policyFile)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		p.SetPolicy(policy)
+	}
+	p.SetCgroup(proxy.CgroupOverride(*cgroupParent))
+	p.SetAuditTrail(proxy.NewAuditTrail(ioutil.Discard))
 
-	inFile, _ := os.Open("/proc/self/cgroup")
-	defer inFile.Close()
+	m := mux.NewRouter()
+	p.RegisterRoutes(m)
 
-	pids := regexp.MustCompile(`^[0-9]+:pids:/docker/([0-9a-z]+)`)
-	scanner := bufio.NewScanner(inFile)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		me := pids.FindStringSubmatch(scanner.Text())
-		if len(me) > 0 {
-			return me[1], nil
+	changed := 0
+	results, err := proxy.Replay(m, exchanges, *timeout)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	for _, r := range results {
+		fmt.Println(r.String())
+		if r.Changed() {
+			changed++
 		}
 	}
-	return "", errors.New("not running inside a container")
+	fmt.Printf("%d requests replayed, %d decisions changed\n", len(results), changed)
+	if changed > 0 {
+		return 1
+	}
+	return 0
 }
 
 func selfContainerName() (string, error) {
@@ -0,0 +1,355 @@
+package proxy
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DefaultArchiveSize is the default limit on size of files uploaded to a container at once
+const DefaultArchiveSize = "1GB"
+
+// mode bits of setuid and setgid files in a tar header
+const (
+	setuidMode = 04000
+	setgidMode = 02000
+)
+
+// size of tar headers, content being padded to it
+const tarBlockSize = 512
+
+// symlinks followed when resolving a container path before giving up, as Linux does
+const maxSymlinks = 40
+
+// ArchivePolicy restricts files uploaded to containers with `docker cp`
+type ArchivePolicy struct {
+	// Maximum size of files in an archive, default is 1GB
+	MaxSize string `json:"maxSize,omitempty"`
+	// Destination path prefixes by image, as a reference or pattern like `maven:*`. Uploads to containers of an
+	// image without any are not restricted by path
+	Paths map[string][]string `json:"paths,omitempty"`
+	// Maximum size of an archive downloaded from a container, default is 1GB
+	MaxDownloadSize string `json:"maxDownloadSize,omitempty"`
+	// Path prefixes which can be downloaded, by image like Paths
+	DownloadPaths map[string][]string `json:"downloadPaths,omitempty"`
+}
+
+func (a ArchivePolicy) validate() error {
+	for _, size := range []string{a.MaxSize, a.MaxDownloadSize} {
+		if size == "" {
+			continue
+		}
+		if _, err := units.FromHumanSize(size); err != nil {
+			return errors.Wrap(err, "Invalid archive policy")
+		}
+	}
+	patterns := []string{}
+	for pattern := range a.Paths {
+		patterns = append(patterns, pattern)
+	}
+	for pattern := range a.DownloadPaths {
+		patterns = append(patterns, pattern)
+	}
+	return validatePatterns(patterns)
+}
+
+func (a ArchivePolicy) maxSize() int64 {
+	return humanSizeOrDefault(a.MaxSize, DefaultArchiveSize)
+}
+
+func (a ArchivePolicy) maxDownloadSize() int64 {
+	return humanSizeOrDefault(a.MaxDownloadSize, DefaultArchiveSize)
+}
+
+func humanSizeOrDefault(size, def string) int64 {
+	if size == "" {
+		size = def
+	}
+	b, _ := units.FromHumanSize(size) // validated on load
+	return b
+}
+
+/**
+ Path prefixes allowed for a container, by its image, nil meaning any. Rules are matched against tags and
+ repositories of the image container runs, as the reference it was created with can be a digest or an image ID. An
+ image without any can't be matched, so it is denied.
+ */
+func (p *Proxy) containerPaths(ctx context.Context, byImage map[string][]string, container types.ContainerJSON) ([]string, error) {
+	image, _, err := p.client.ImageInspectWithRaw(ctx, container.Image)
+	if err != nil {
+		return nil, err
+	}
+	if len(image.RepoTags)+len(image.RepoDigests) == 0 {
+		return nil, denied("image %s has no tag, archive policy can't be checked", stringid.TruncateID(image.ID))
+	}
+	var paths []string
+	for pattern, p := range byImage {
+		if matchesImage(pattern, image) {
+			paths = append(paths, p...)
+		}
+	}
+	return paths, nil
+}
+
+/**
+ Tell if an image matches a pattern, by any of its tags. An image pulled by digest only has no tag, so pattern
+ repository is matched against its repositories.
+ */
+func matchesImage(pattern string, image types.ImageInspect) bool {
+	for _, t := range image.RepoTags {
+		named, err := reference.ParseNormalizedNamed(t)
+		if err != nil {
+			continue
+		}
+		if tagged, ok := named.(reference.NamedTagged); ok && matchesTag(pattern, tagged) {
+			return true
+		}
+	}
+	repository := pattern
+	if i := strings.LastIndex(pattern, ":"); i > strings.LastIndex(pattern, "/") {
+		repository = pattern[:i]
+	}
+	for _, d := range image.RepoDigests {
+		named, err := reference.ParseNormalizedNamed(d)
+		if err != nil {
+			continue
+		}
+		if ok, _ := path.Match(repository, reference.FamiliarName(named)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveInspector checks a tar stream on its way to daemon, entry by entry, and cuts it on first violation
+type archiveInspector struct {
+	dest  string   // where archive is extracted in container
+	limit int64    // maximum size of files
+	paths []string // destination prefixes allowed, nil meaning any
+	size  int64
+	links []string // symlinks in archive, which later entries can't go through
+	done  chan error
+}
+
+/**
+ Start inspecting an archive, returning the checked stream. Archive is decompressed and re-encoded, so daemon only
+ gets entries which have been checked.
+ */
+func (a *archiveInspector) inspect(body io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	a.done = make(chan error, 1)
+	go func() {
+		err := a.copy(body, pw)
+		pw.CloseWithError(err)
+		a.done <- err
+	}()
+	return pr
+}
+
+/**
+ Wait for inspection to complete, returning the violation found, if any
+ */
+func (a *archiveInspector) wait(checked io.ReadCloser) error {
+	// daemon might not have read stream till the end
+	checked.Close()
+	err := <-a.done
+	if err == io.ErrClosedPipe {
+		return nil
+	}
+	return err
+}
+
+func (a *archiveInspector) copy(body io.Reader, w io.Writer) error {
+	in, err := archive.DecompressStream(body)
+	if err != nil {
+		return badRequest("Invalid archive: %s", err.Error())
+	}
+	defer in.Close()
+
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(w)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return badRequest("Invalid archive: %s", err.Error())
+		}
+		if err := a.check(h); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archiveInspector) check(h *tar.Header) error {
+	switch h.Typeflag {
+	case tar.TypeChar, tar.TypeBlock:
+		return denied("device node %s is not authorized in archive", h.Name)
+	}
+	if h.Mode&(setuidMode|setgidMode) != 0 {
+		return denied("setuid or setgid file %s is not authorized in archive", h.Name)
+	}
+	if escapes(h.Name) {
+		return denied("archive entry %s is out of destination", h.Name)
+	}
+	if (h.Typeflag == tar.TypeLink || h.Typeflag == tar.TypeSymlink) && escapes(h.Linkname) {
+		return denied("archive entry %s links out of destination", h.Name)
+	}
+	name := path.Clean(h.Name)
+	for _, l := range a.links {
+		if name != l && underPath(name, l) {
+			return denied("archive entry %s is extracted through symlink %s", h.Name, l)
+		}
+	}
+	if h.Typeflag == tar.TypeSymlink {
+		a.links = append(a.links, name)
+	}
+
+	a.size += h.Size
+	if a.size > a.limit {
+		return denied("archive exceeds %s limit", units.HumanSize(float64(a.limit)))
+	}
+
+	if target := path.Join(a.dest, h.Name); a.paths != nil && !underAny(target, a.paths) {
+		return denied("%s is not an authorized destination", target)
+	}
+	return nil
+}
+
+func underAny(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if underPath(p, path.Clean(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ Size of a tar archive holding a single file, as daemon sends it: header, content padded to blocks and end of archive
+ marker. A long name takes an extended header.
+ */
+func tarSize(name string, size int64) int64 {
+	blocks := func(n int64) int64 {
+		return (n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	}
+	n := tarBlockSize + blocks(size) + 2*tarBlockSize
+	if len(name) > 100 {
+		n += tarBlockSize + blocks(int64(len(name))+32)
+	}
+	return n
+}
+
+/**
+ Check a path can be downloaded from a container. Daemon follows symlinks in every component of path, so each one
+ is resolved and has to stay under an allowed prefix, as the path itself.
+ */
+func (p *Proxy) checkDownload(ctx context.Context, container string, requested string, stat types.ContainerPathStat) error {
+	limit := p.policy.Archive.maxDownloadSize()
+	if size := tarSize(stat.Name, stat.Size); stat.Mode.IsRegular() && size > limit {
+		return denied("%s exceeds %s download limit, as a %s archive", requested, units.HumanSize(float64(limit)), units.HumanSize(float64(size)))
+	}
+	if len(p.policy.Archive.DownloadPaths) == 0 {
+		return nil
+	}
+	json, err := p.client.ContainerInspect(ctx, container)
+	if err != nil {
+		return err
+	}
+	allowed, err := p.containerPaths(ctx, p.policy.Archive.DownloadPaths, json)
+	if err != nil {
+		return err
+	}
+	if allowed == nil {
+		return nil
+	}
+	target := path.Clean("/" + requested)
+	if !underAny(target, allowed) {
+		return denied("%s is not authorized for download", target)
+	}
+	link, out, err := p.linkOut(ctx, container, target, allowed)
+	if err != nil {
+		return err
+	}
+	if link != "" {
+		return denied("%s is not authorized for download, %s links to %s", target, link, out)
+	}
+	return nil
+}
+
+/**
+ Resolve symlinks in a container path, as daemon does, returning the first one leading out of allowed prefixes and its
+ target, if any. Resolution stops on a missing component, which an upload would create.
+ */
+func (p *Proxy) linkOut(ctx context.Context, container string, target string, allowed []string) (string, string, error) {
+	resolved := "/"
+	rest := strings.Split(strings.TrimPrefix(path.Clean("/"+target), "/"), "/")
+	for links := 0; len(rest) > 0; {
+		c := rest[0]
+		rest = rest[1:]
+		if c == "" {
+			continue
+		}
+		dir := path.Join(resolved, c)
+		s, err := p.client.ContainerStatPath(ctx, container, dir)
+		if client.IsErrNotFound(err) {
+			return "", "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if s.Mode&os.ModeSymlink == 0 {
+			resolved = dir
+			continue
+		}
+		// bounded, as daemon gives up on symlink loops
+		if links++; links > maxSymlinks {
+			return "", "", denied("too many levels of symbolic links in %s", target)
+		}
+		link := s.LinkTarget
+		if !path.IsAbs(link) {
+			link = path.Join(resolved, link)
+		}
+		link = path.Clean(link)
+		if !underAny(link, allowed) {
+			return dir, link, nil
+		}
+		// link target is resolved in turn
+		resolved = "/"
+		rest = append(strings.Split(strings.TrimPrefix(link, "/"), "/"), rest...)
+	}
+	return "", "", nil
+}
+
+/**
+ Tell if an archive entry path is absolute or goes up with `..`
+ */
+func escapes(name string) bool {
+	if path.IsAbs(name) {
+		return true
+	}
+	for _, c := range strings.Split(name, "/") {
+		if c == ".." {
+			return true
+		}
+	}
+	return false
+}
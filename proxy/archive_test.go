@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudbees/lancelot/proxy/fake"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+/**
+ Build a tar archive, regular files getting their name as content
+ */
+func tarball(t *testing.T, headers ...*tar.Header) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(h.Name))
+		}
+		if h.Mode == 0 {
+			h.Mode = 0644
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write([]byte(h.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+/**
+ Upload an archive to a container, as `docker cp` does
+ */
+func (tp *testProxy) upload(id, query string, archive []byte) *http.Response {
+	tp.t.Helper()
+	req, err := http.NewRequest("PUT", tp.server.URL+"/v"+fake.APIVersion+"/containers/"+id+"/archive?"+query, bytes.NewReader(archive))
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	return res
+}
+
+func TestArchiveIsInspected(t *testing.T) {
+	tp := newTestProxy(t, Policy{Archive: ArchivePolicy{MaxSize: "100B"}})
+	defer tp.Close()
+	id := tp.run("busybox")
+
+	tp.expect(tp.upload(id, "path=/src", tarball(t,
+		&tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "app/main.go", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "app/run.sh", Typeflag: tar.TypeReg, Mode: 0755},
+		&tar.Header{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "main.go"},
+	)), http.StatusOK)
+
+	// compressed archives are accepted too
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(tarball(t, &tar.Header{Name: "lib.go", Typeflag: tar.TypeReg}))
+	zw.Close()
+	tp.expect(tp.upload(id, "path=/src", gz.Bytes()), http.StatusOK)
+
+	for _, f := range []string{"/src/app/main.go", "/src/lib.go"} {
+		content, _, err := tp.daemon.CopyFromContainer(context.Background(), id, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content.Close()
+	}
+
+	for message, h := range map[string]*tar.Header{
+		"device node dev/sda is not authorized":        {Name: "dev/sda", Typeflag: tar.TypeBlock, Devmajor: 8},
+		"device node null is not authorized":           {Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		"setuid or setgid file su is not authorized":   {Name: "su", Typeflag: tar.TypeReg, Mode: 04755},
+		"setuid or setgid file sg is not authorized":   {Name: "sg", Typeflag: tar.TypeReg, Mode: 02755},
+		"archive entry /etc/passwd is out of":          {Name: "/etc/passwd", Typeflag: tar.TypeReg},
+		"archive entry app/../../etc/passwd is out of": {Name: "app/../../etc/passwd", Typeflag: tar.TypeReg},
+		"archive entry shadow links out of":            {Name: "shadow", Typeflag: tar.TypeLink, Linkname: "../etc/shadow"},
+		"archive entry etc links out of":               {Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		"archive entry up links out of":                {Name: "up", Typeflag: tar.TypeSymlink, Linkname: "app/../.."},
+		"archive exceeds 100B limit":                   {Name: "big/" + strings.Repeat("x", 100), Typeflag: tar.TypeReg},
+	} {
+		tp.expectError(tp.upload(id, "path=/src", tarball(t, h)), http.StatusForbidden, message)
+	}
+	// a symlink inside destination is fine, until an entry is extracted through it
+	tp.expectError(tp.upload(id, "path=/src", tarball(t,
+		&tar.Header{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "app"},
+		&tar.Header{Name: "x/passwd", Typeflag: tar.TypeReg},
+	)), http.StatusForbidden, "archive entry x/passwd is extracted through symlink x")
+	tp.expectError(tp.upload(id, "path=/src", []byte("not an archive")), http.StatusBadRequest, "Invalid archive")
+}
+
+func TestArchiveDestinationsByImage(t *testing.T) {
+	tp := newTestProxy(t, Policy{Archive: ArchivePolicy{Paths: map[string][]string{"busybox": {"/workspace", "/tmp"}}}})
+	defer tp.Close()
+	id := tp.run("busybox")
+
+	tp.expect(tp.upload(id, "path=/workspace", tarball(t, &tar.Header{Name: "src/main.go", Typeflag: tar.TypeReg})), http.StatusOK)
+	tp.expect(tp.upload(id, "path=/", tarball(t, &tar.Header{Name: "tmp/x", Typeflag: tar.TypeReg})), http.StatusOK)
+	tp.expectError(tp.upload(id, "path=/", tarball(t, &tar.Header{Name: "etc/profile", Typeflag: tar.TypeReg})), http.StatusForbidden, "/etc/profile is not an authorized destination")
+	tp.expectError(tp.upload(id, "path=/workspace-evil", tarball(t, &tar.Header{Name: "x", Typeflag: tar.TypeReg})), http.StatusForbidden, "/workspace-evil/x is not an authorized destination")
+
+	// daemon extracts where destination resolves to
+	links := tarball(t,
+		&tar.Header{Name: "conf", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+		&tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "../etc"},
+		&tar.Header{Name: "tmp", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+	)
+	if err := tp.daemon.CopyToContainer(context.Background(), id, "/workspace", bytes.NewReader(links), types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.upload(id, "path=/workspace/conf", tarball(t, &tar.Header{Name: "profile", Typeflag: tar.TypeReg})), http.StatusForbidden, "/workspace/conf is not an authorized destination, /workspace/conf links to /etc")
+	tp.expectError(tp.upload(id, "path=/workspace/a", tarball(t, &tar.Header{Name: "profile", Typeflag: tar.TypeReg})), http.StatusForbidden, "/workspace/b links to /etc")
+	tp.expect(tp.upload(id, "path=/workspace/tmp", tarball(t, &tar.Header{Name: "x", Typeflag: tar.TypeReg})), http.StatusOK)
+}
+
+func TestArchiveDestinationsByImageID(t *testing.T) {
+	tp := newTestProxy(t, Policy{Archive: ArchivePolicy{
+		Paths:         map[string][]string{"busybox": {"/workspace"}},
+		DownloadPaths: map[string][]string{"busybox": {"/workspace"}},
+	}})
+	defer tp.Close()
+	ctx := context.Background()
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	busybox, _, err := tp.daemon.ImageInspectWithRaw(ctx, "busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rules apply to the image, however container refers to it
+	id := tp.create(map[string]interface{}{"Image": busybox.ID}, "")
+	tp.expect(tp.do("POST", "/containers/"+id+"/start", nil), http.StatusNoContent)
+	tp.expectError(tp.upload(id, "path=/etc", tarball(t, &tar.Header{Name: "profile", Typeflag: tar.TypeReg})), http.StatusForbidden, "/etc/profile is not an authorized destination")
+	profile := tarball(t, &tar.Header{Name: "profile", Typeflag: tar.TypeReg})
+	if err := tp.daemon.CopyToContainer(ctx, id, "/etc", bytes.NewReader(profile), types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/etc/profile", nil), http.StatusForbidden, "/etc/profile is not authorized for download")
+
+	// an untagged image can't be matched against rules
+	dangling, err := tp.daemon.AddImage("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: dangling}, &container.HostConfig{}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.proxy.addContainer(c.ID)
+	tp.daemon.ContainerStart(ctx, c.ID, types.ContainerStartOptions{})
+	tp.expectError(tp.upload(c.ID, "path=/workspace", tarball(t, &tar.Header{Name: "x", Typeflag: tar.TypeReg})), http.StatusForbidden, "has no tag, archive policy can't be checked")
+	if err := tp.daemon.CopyToContainer(ctx, c.ID, "/workspace", bytes.NewReader(profile), types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.do("GET", "/containers/"+c.ID+"/archive?path=/workspace/profile", nil), http.StatusForbidden, "has no tag, archive policy can't be checked")
+}
+
+func TestArchiveOverwriteFlag(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	id := tp.run("busybox")
+
+	tp.expect(tp.upload(id, "path=/src", tarball(t, &tar.Header{Name: "dir/file", Typeflag: tar.TypeReg})), http.StatusOK)
+	res := tp.upload(id, "path=/src&noOverwriteDirNonDir=1", tarball(t, &tar.Header{Name: "dir", Typeflag: tar.TypeReg}))
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK || !strings.Contains(string(b), "cannot overwrite directory") {
+		t.Fatalf("expected directory not to be overwritten, got %d: %s", res.StatusCode, b)
+	}
+	tp.expect(tp.upload(id, "path=/src", tarball(t, &tar.Header{Name: "dir", Typeflag: tar.TypeReg})), http.StatusOK)
+}
+
+func TestArchiveStat(t *testing.T) {
+	tp := newTestProxy(t, Policy{Archive: ArchivePolicy{DownloadPaths: map[string][]string{"busybox": {"/workspace"}}}})
+	defer tp.Close()
+	id := tp.run("busybox")
+	tp.expect(tp.upload(id, "path=/etc", tarball(t, &tar.Header{Name: "app.conf", Typeflag: tar.TypeReg})), http.StatusOK)
+
+	// stat is not restricted by download paths, `docker cp` needs it to tell if destination exists
+	res := tp.do("HEAD", "/containers/"+id+"/archive?path=/etc/app.conf", nil)
+	tp.expect(res, http.StatusOK)
+	b, err := base64.StdEncoding.DecodeString(res.Header.Get("X-Docker-Container-Path-Stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := types.ContainerPathStat{}
+	if err := json.Unmarshal(b, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name != "app.conf" || stat.Size != int64(len("app.conf")) {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+
+	tp.expect(tp.do("HEAD", "/containers/"+id+"/archive?path=/missing", nil), http.StatusNotFound)
+	tp.expect(tp.do("HEAD", "/containers/"+tp.foreignContainer()+"/archive?path=/etc", nil), http.StatusNotFound)
+}
+
+func TestArchiveDownloads(t *testing.T) {
+	tp := newTestProxy(t, Policy{Archive: ArchivePolicy{
+		MaxDownloadSize: "4KB",
+		DownloadPaths:   map[string][]string{"busybox": {"/workspace"}},
+	}})
+	defer tp.Close()
+	audit := &auditBuffer{}
+	tp.proxy.SetAuditTrail(NewAuditTrail(audit))
+	id := tp.run("busybox")
+	big := "big/" + strings.Repeat("x", 4500)
+
+	tp.expect(tp.upload(id, "path=/workspace", tarball(t,
+		&tar.Header{Name: "target/app.jar", Typeflag: tar.TypeReg},
+		&tar.Header{Name: big, Typeflag: tar.TypeReg},
+	)), http.StatusOK)
+	// under limit itself, not once archived
+	near := &bytes.Buffer{}
+	tw := tar.NewWriter(near)
+	tw.WriteHeader(&tar.Header{Name: "near.bin", Typeflag: tar.TypeReg, Mode: 0644, Size: 3900})
+	tw.Write(bytes.Repeat([]byte("x"), 3900))
+	tw.Close()
+	if err := tp.daemon.CopyToContainer(context.Background(), id, "/workspace", near, types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// as a build step could create, uploads can't
+	link := tarball(t, &tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"})
+	if err := tp.daemon.CopyToContainer(context.Background(), id, "/workspace", bytes.NewReader(link), types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expect(tp.upload(id, "path=/etc", tarball(t, &tar.Header{Name: "shadow", Typeflag: tar.TypeReg})), http.StatusOK)
+
+	tr := tar.NewReader(bytes.NewReader(tp.expect(tp.do("GET", "/containers/"+id+"/archive?path=/workspace/target", nil), http.StatusOK)))
+	if h, err := tr.Next(); err != nil || h.Name != "target/app.jar" {
+		t.Fatalf("expected target/app.jar in archive, got %v %v", h, err)
+	}
+
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/etc/shadow", nil), http.StatusForbidden, "/etc/shadow is not authorized for download")
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/workspace/etc", nil), http.StatusForbidden, "/workspace/etc links to /etc")
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/workspace/etc/shadow", nil), http.StatusForbidden, "/workspace/etc links to /etc")
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/workspace/"+big, nil), http.StatusForbidden, "exceeds 4kB download limit")
+	tp.expectError(tp.do("GET", "/containers/"+id+"/archive?path=/workspace/near.bin", nil), http.StatusForbidden, "exceeds 4kB download limit, as a 5.632kB archive")
+
+	// a directory is aborted once over limit, so client can't take a partial archive as complete
+	res := tp.do("GET", "/containers/"+id+"/archive?path=/workspace/big", nil)
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		t.Fatalf("expected download to be aborted, got %d bytes", len(b))
+	}
+
+	events := []AuditEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		e := AuditEvent{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Action == "download" {
+			events = append(events, e)
+		}
+	}
+	if len(events) != 7 {
+		t.Fatalf("expected 7 downloads in audit trail, got %d", len(events))
+	}
+	if events[0].Details["aborted"] != nil || events[1].Details["denied"] == nil || events[6].Details["aborted"] != true {
+		t.Fatalf("unexpected audit events %+v", events)
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEvent is a record in audit trail
+type AuditEvent struct {
+	Time     time.Time              `json:"time"`
+	Action   string                 `json:"action"`
+	Resource string                 `json:"resource,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditTrail writes audit events as JSON lines
+type AuditTrail struct {
+	out io.Writer
+	mux sync.Mutex
+}
+
+// audit trail used until one is set, on standard output
+var defaultAuditTrail = NewAuditTrail(os.Stdout)
+
+func NewAuditTrail(out io.Writer) *AuditTrail {
+	return &AuditTrail{out: out}
+}
+
+func (a *AuditTrail) Record(action, resource string, details map[string]interface{}) {
+	b, err := json.Marshal(&AuditEvent{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Resource: resource,
+		Details:  details,
+	})
+	if err != nil {
+		return
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.out.Write(append(b, '\n'))
+}
+
+func (p *Proxy) SetAuditTrail(audit *AuditTrail) {
+	p.audit = audit
+}
+
+func (p *Proxy) record(action, resource string, details map[string]interface{}) {
+	audit := p.audit
+	if audit == nil {
+		audit = defaultAuditTrail
+	}
+	audit.Record(action, resource, details)
+}
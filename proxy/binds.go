@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/symlink"
+	"github.com/pkg/errors"
+)
+
+// BindMountRule allows sidecars to bind mount a host path and everything below it, or only the path itself when
+// read-write
+type BindMountRule struct {
+	Path string `json:"path"`
+	// "ro" (default) forces bind mounts read-only, "rw" let sidecar choose
+	Mode string `json:"mode,omitempty"`
+	// Propagation modes sidecar can set. Default is private ones only, so mounts don't leak between sidecar and host
+	Propagation []mount.Propagation `json:"propagation,omitempty"`
+}
+
+// propagation modes allowed when rule doesn't set any
+var privatePropagations = []mount.Propagation{mount.PropagationPrivate, mount.PropagationRPrivate}
+
+func (b BindMountRule) validate() error {
+	if !path.IsAbs(b.Path) {
+		return errors.Errorf("Bind mount path must be absolute: %s", b.Path)
+	}
+	if b.Mode != "" && b.Mode != "ro" && b.Mode != "rw" {
+		return errors.Errorf("Invalid bind mount mode for %s: %s", b.Path, b.Mode)
+	}
+	for _, p := range b.Propagation {
+		if !isPropagation(string(p)) {
+			return errors.Errorf("Invalid bind mount propagation for %s: %s", b.Path, p)
+		}
+	}
+	return nil
+}
+
+/**
+ Check rules don't nest a read-only prefix in a read-write one, where sidecars could replace paths with symlinks
+ */
+func validateBindMounts(rules []BindMountRule) error {
+	for _, b := range rules {
+		if err := b.validate(); err != nil {
+			return err
+		}
+		for _, rw := range rules {
+			if rw.Mode == "rw" && rw.Path != b.Path && underPath(path.Clean(b.Path), path.Clean(rw.Path)) {
+				return errors.Errorf("Bind mount path %s can't be under read-write path %s", b.Path, rw.Path)
+			}
+		}
+	}
+	return nil
+}
+
+func (b BindMountRule) allowsPropagation(p mount.Propagation) bool {
+	allowed := b.Propagation
+	if len(allowed) == 0 {
+		allowed = privatePropagations
+	}
+	for _, a := range allowed {
+		if a == p {
+			return true
+		}
+	}
+	return false
+}
+
+func isPropagation(option string) bool {
+	for _, p := range mount.Propagations {
+		if string(p) == option {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ Tell if path is prefix, or under prefix. Both are expected to be clean.
+ */
+func underPath(p, prefix string) bool {
+	return p == prefix || prefix == "/" || strings.HasPrefix(p, prefix+"/")
+}
+
+/**
+ Most specific rule allowing a host path, if any
+ */
+func (p *Policy) bindMountRule(source string) *BindMountRule {
+	var rule *BindMountRule
+	for i, b := range p.BindMounts {
+		prefix := path.Clean(b.Path)
+		if underPath(source, prefix) && (rule == nil || len(prefix) > len(path.Clean(rule.Path))) {
+			rule = &p.BindMounts[i]
+		}
+	}
+	return rule
+}
+
+/**
+ Check a bind mount against policy allowlist, and return it with source resolved on host and read-only enforced.
+ ok is false when no rule applies to source.
+
+ Daemon resolves source again when container starts, so the check only holds for paths sidecars can't modify: below a
+ read-write prefix, a sidecar could replace a checked directory with a symlink in between, so only the prefix itself
+ can be bound.
+ */
+func (p *Proxy) hostMount(m mount.Mount) (mount.Mount, bool, error) {
+	m.Source = path.Clean("/" + m.Source)
+	rule := p.policy.bindMountRule(m.Source)
+	if rule == nil {
+		return m, false, nil
+	}
+	if err := rule.check(&m, m.Source); err != nil {
+		return m, true, err
+	}
+
+	// host path could be a symlink to anywhere. Resolve it as daemon would, within host filesystem
+	if p.hostRoot == "" {
+		return m, true, denied("bind mount of %s can't be checked for symlinks, host filesystem is not mounted in Lancelot", m.Source)
+	}
+	resolved, err := symlink.FollowSymlinkInScope(path.Join(p.hostRoot, m.Source), p.hostRoot)
+	if err != nil {
+		return m, true, err
+	}
+	resolved = path.Clean("/" + strings.TrimPrefix(resolved, path.Clean(p.hostRoot)))
+	if !underPath(resolved, path.Clean(rule.Path)) {
+		// like /etc/localtime linking to /usr/share/zoneinfo, the real location must be allowed on its own
+		target := p.policy.bindMountRule(resolved)
+		if target == nil {
+			return m, true, denied("bind mount of %s resolves to %s, out of %s", m.Source, resolved, rule.Path)
+		}
+		if err := target.check(&m, resolved); err != nil {
+			return m, true, err
+		}
+	}
+	m.Source = resolved
+	return m, true, nil
+}
+
+/**
+ Check propagation and mode of a bind mount of source against the rule allowing it, making it read-only as needed
+ */
+func (b *BindMountRule) check(m *mount.Mount, source string) error {
+	if m.BindOptions != nil && m.BindOptions.Propagation != "" && !b.allowsPropagation(m.BindOptions.Propagation) {
+		return denied("bind mount propagation %s is not authorized for %s", m.BindOptions.Propagation, source)
+	}
+	if b.Mode != "rw" {
+		m.ReadOnly = true
+	} else if source != path.Clean(b.Path) {
+		return denied("bind mount of %s is not authorized, only %s itself can be bound as it is writable", source, b.Path)
+	}
+	return nil
+}
+
+/**
+ Check a `/path:/target[:options]` bind against policy allowlist. ok is false when no rule applies to path.
+ */
+func (p *Proxy) hostBind(bind string) (string, bool, error) {
+	source, target, options, err := parseBind(bind)
+	if err != nil {
+		return "", false, err
+	}
+	m := mount.Mount{Type: mount.TypeBind, Source: source, ReadOnly: contains(options, "ro")}
+	for _, o := range options {
+		if isPropagation(o) {
+			m.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(o)}
+		}
+	}
+	m, ok, err := p.hostMount(m)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+	for _, o := range options {
+		// daemon would relabel host files, whatever the mode
+		if o == "z" || o == "Z" {
+			return "", true, denied("bind mount option %s is not authorized on host path %s", o, source)
+		}
+	}
+	allowed := bindModes
+	if m.BindOptions != nil {
+		allowed = append([]string{string(m.BindOptions.Propagation)}, bindModes...)
+	}
+	return formatBind(m.Source, target, options, m.ReadOnly, allowed), true, nil
+}
+
+/**
+ Split a `source:target[:options]` bind, options being comma separated
+ */
+func parseBind(bind string) (string, string, []string, error) {
+	spec := strings.SplitN(bind, ":", 3)
+	if len(spec) < 2 {
+		return "", "", nil, badRequest("invalid volume specification: '%s'", bind)
+	}
+	options := []string{}
+	if len(spec) > 2 && spec[2] != "" {
+		options = strings.Split(spec[2], ",")
+	}
+	return spec[0], spec[1], options, nil
+}
+
+/**
+ Build a bind back from its parts, keeping allowed options only and enforcing read-only
+ */
+func formatBind(source, target string, options []string, readOnly bool, allowed []string) string {
+	kept := []string{}
+	for _, o := range options {
+		if contains(allowed, o) && !(readOnly && o == "rw") {
+			kept = append(kept, o)
+		}
+	}
+	if readOnly && !contains(kept, "ro") {
+		kept = append(kept, "ro")
+	}
+	if len(kept) == 0 {
+		return source + ":" + target
+	}
+	return fmt.Sprintf("%s:%s:%s", source, target, strings.Join(kept, ","))
+}
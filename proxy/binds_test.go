@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+/**
+ Fake host filesystem, with a cache directory holding symlinks inside and out of it
+ */
+func testHostRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "lancelot-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"etc", "cache/maven", "shared/sub"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/localtime"), []byte("UTC"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(root, "cache/escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("maven", filepath.Join(root, "cache/m2")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestAllowlistedBindMounts(t *testing.T) {
+	tp := newTestProxy(t, Policy{BindMounts: []BindMountRule{
+		{Path: "/etc/localtime"},
+		{Path: "/cache"},
+		{Path: "/shared", Mode: "rw"},
+	}})
+	defer tp.Close()
+	root := testHostRoot(t)
+	defer os.RemoveAll(root)
+	tp.proxy.SetHostRoot(root)
+
+	id := tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{
+			"/etc/localtime:/etc/localtime",
+			"//cache/./m2:/root/.m2:rw,rprivate",
+			"/shared/:/shared:rw",
+		}},
+	}, "")
+	m := tp.mounts(id)
+	if len(m) != 3 || m[0].Source != "/etc/localtime" || m[0].RW || m[1].Source != "/cache/maven" || m[1].RW || m[2].Source != "/shared" || !m[2].RW {
+		t.Fatalf("expected localtime and maven cache to be bound read-only and shared read-write, got %v", m)
+	}
+
+	id = tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{"Mounts": []map[string]interface{}{{
+			"Type": "bind", "Source": "/etc/localtime", "Target": "/etc/localtime",
+		}}},
+	}, "")
+	m = tp.mounts(id)
+	if len(m) != 1 || m[0].Type != mount.TypeBind || m[0].RW {
+		t.Fatalf("expected localtime to be bound read-only, got %v", m)
+	}
+
+	for bind, message := range map[string]string{
+		"/etc/passwd:/etc/passwd":          "bind mount are not authorized",
+		"/cache/../etc:/etc":               "bind mount are not authorized",
+		"/cache/escape:/etc":               "resolves to /etc, out of /cache",
+		"/cache/escape/passwd:/x":          "resolves to /etc/passwd, out of /cache",
+		"/cache/maven:/root/.m2:z":         "bind mount option z is not authorized on host path /cache/maven",
+		"/etc/localtime:/etc/localtime:Z":  "bind mount option Z is not authorized on host path /etc/localtime",
+		"/cache/maven:/root/.m2:rshared":   "propagation rshared is not authorized",
+		"/shared/sub:/x:ro":                "only /shared itself can be bound",
+		"/etc/localtime:/etc/localtime:rw": "",
+	} {
+		res := tp.do("POST", "/containers/create", map[string]interface{}{
+			"Image":      "busybox",
+			"HostConfig": map[string]interface{}{"Binds": []string{bind}},
+		})
+		if message == "" {
+			// read-only is enforced rather than denied
+			tp.expect(res, http.StatusCreated)
+			continue
+		}
+		tp.expectError(res, http.StatusForbidden, message)
+	}
+}
+
+func TestAllowlistedBindMountsThroughSymlink(t *testing.T) {
+	tp := newTestProxy(t, Policy{BindMounts: []BindMountRule{{Path: "/etc/localtime"}, {Path: "/usr/share/zoneinfo"}}})
+	defer tp.Close()
+	root := testHostRoot(t)
+	defer os.RemoveAll(root)
+	tp.proxy.SetHostRoot(root)
+	if err := os.MkdirAll(filepath.Join(root, "usr/share/zoneinfo/Europe"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "usr/share/zoneinfo/Europe/Paris"), []byte("CET"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localtime := filepath.Join(root, "etc/localtime")
+	if err := os.Remove(localtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/share/zoneinfo/Europe/Paris", localtime); err != nil {
+		t.Fatal(err)
+	}
+
+	id := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/etc/localtime:/etc/localtime"}},
+	}, "")
+	m := tp.mounts(id)
+	if len(m) != 1 || m[0].Source != "/usr/share/zoneinfo/Europe/Paris" || m[0].RW {
+		t.Fatalf("expected localtime to be bound read-only from its zone file, got %v", m)
+	}
+
+	// real location must be allowed as well
+	tp.proxy.policy.BindMounts = []BindMountRule{{Path: "/etc/localtime"}}
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/etc/localtime:/etc/localtime"}},
+	}), http.StatusForbidden, "resolves to /usr/share/zoneinfo/Europe/Paris, out of /etc/localtime")
+}
+
+func TestAllowlistedBindMountsNeedHostRoot(t *testing.T) {
+	tp := newTestProxy(t, Policy{BindMounts: []BindMountRule{{Path: "/etc/localtime"}}})
+	defer tp.Close()
+
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/etc/localtime:/etc/localtime"}},
+	}), http.StatusForbidden, "host filesystem is not mounted in Lancelot")
+}
+
+func TestBindMountRulesCantNestInReadWrite(t *testing.T) {
+	if err := validateBindMounts([]BindMountRule{{Path: "/cache", Mode: "rw"}, {Path: "/cache/maven"}}); err == nil {
+		t.Fatal("expected read-only path under a read-write one to be rejected")
+	}
+	if err := validateBindMounts([]BindMountRule{{Path: "/cache"}, {Path: "/cache/maven", Mode: "rw"}, {Path: "/cachex"}}); err != nil {
+		t.Fatal(err)
+	}
+}
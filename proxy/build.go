@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"net/http"
-	"golang.org/x/net/context"
 	"github.com/docker/docker/api/server/httputils"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/api/types"
@@ -17,14 +16,13 @@ import (
 func (p *Proxy) build(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	tags := r.Form["t"]
 	options := &types.ImageBuildOptions{
 		Dockerfile: r.FormValue("dockerfile"),
-		Tags: tags,    // TODO support tag black/white-list
+		Tags: tags,
 
 		SuppressOutput: httputils.BoolValue(r, "q"),
 		NoCache: httputils.BoolValue(r, "nocache"),
@@ -48,7 +46,7 @@ func (p *Proxy) build(w http.ResponseWriter, r *http.Request) {
 	if r.Form.Get("shmsize") != "" {
 		shmSize, err := strconv.ParseInt(r.Form.Get("shmsize"), 10, 64)
 		if err != nil {
-			http.Error(w, "Import is not supported", http.StatusBadRequest)
+			writeError(w, r, badRequest("Invalid build options: %s", err.Error()))
 			return
 		}
 		options.ShmSize = shmSize
@@ -58,7 +56,7 @@ func (p *Proxy) build(w http.ResponseWriter, r *http.Request) {
 	ulimitsJSON := r.FormValue("ulimits")
 	if ulimitsJSON != "" {
 		if err := json.Unmarshal([]byte(ulimitsJSON), &buildUlimits); err != nil {
-			http.Error(w, "Import is not supported", http.StatusBadRequest)
+			writeError(w, r, badRequest("Invalid build options: %s", err.Error()))
 			return
 		}
 		options.Ulimits = buildUlimits
@@ -69,35 +67,54 @@ func (p *Proxy) build(w http.ResponseWriter, r *http.Request) {
 
 	if buildArgsJSON != "" {
 		if err := json.Unmarshal([]byte(buildArgsJSON), &buildArgs); err != nil {
-			http.Error(w, "Import is not supported", http.StatusBadRequest)
+			writeError(w, r, badRequest("Invalid build options: %s", err.Error()))
 			return
 		}
 		options.BuildArgs = buildArgs
 	}
 
+	buildArgs, err := p.injectBuildArgs(options.BuildArgs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	options.BuildArgs = buildArgs
+
 	var labels = map[string]string{}
 	labelsJSON := r.FormValue("labels")
 	if labelsJSON != "" {
 		if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
-			http.Error(w, "Import is not supported", http.StatusBadRequest)
+			writeError(w, r, badRequest("Invalid build options: %s", err.Error()))
 			return
 		}
-		options.Labels = labels
 	}
+	options.Labels = p.injectLabels(retentionLabels(p.policy.Teardown.Images, labels))
 
 	var cacheFrom = []string{}
 	cacheFromJSON := r.FormValue("cachefrom")
 	if cacheFromJSON != "" {
 		if err := json.Unmarshal([]byte(cacheFromJSON), &cacheFrom); err != nil {
-			http.Error(w, "Import is not supported", http.StatusBadRequest)
+			writeError(w, r, badRequest("Invalid build options: %s", err.Error()))
 			return
 		}
 		options.CacheFrom = cacheFrom
 	}
-	
-	res, err := p.client.ImageBuild(context.Background(), r.Body, *options)
+
+	for _, t := range tags {
+		if err := p.checkTag(r.Context(), t, ""); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	if err := p.checkQuota(r.Context(), ImagesQuota, ImagesSizeQuota); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res, err := p.client.ImageBuild(r.Context(), r.Body, *options)
 	if err != nil {
-		http.Error(w, "Import is not supported", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
@@ -111,15 +128,18 @@ func (p *Proxy) build(w http.ResponseWriter, r *http.Request) {
 		tag := tags[0]
 
 		// record both ID and all tags associated with image ID
-		inspect, _, err := p.client.ImageInspectWithRaw(context.Background(), tag)
+		inspect, _, err := p.client.ImageInspectWithRaw(r.Context(), tag)
 		if err != nil {
 			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		p.addImage(inspect.ID)
+		p.addBuiltImage(inspect.ID)
 		for _, t := range inspect.RepoTags {
 			p.addImage(t)
 		}
+		for _, t := range tags {
+			p.addTag(t)
+		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	CgroupfsDriver = "cgroupfs"
+	SystemdDriver  = "systemd"
+)
+
+// Cgroup tells where Lancelot runs in cgroup hierarchy, and where sidecars have to be placed to share its limits
+type Cgroup struct {
+	// Layout which matched our cgroup path
+	Layout string
+	// Driver used by the daemon to manage cgroups, as sidecar parent path syntax depends on it
+	Driver string
+	// Container owning the cgroup, if any
+	ContainerID string
+	// Parent to set for sidecar containers, a path for cgroupfs driver, a slice name for systemd
+	Parent string
+}
+
+// CgroupLayout detects a well known cgroup hierarchy, from cgroup path as read in /proc/self/cgroup
+type CgroupLayout struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Compute cgroup from pattern submatches
+	Resolve func(match []string) *Cgroup
+}
+
+var cgroupLayouts = []CgroupLayout{
+	{
+		// kubelet with systemd driver: /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/docker-<id>.scope
+		Name:    "kubernetes-systemd",
+		Pattern: regexp.MustCompile(`/(kubepods[^/]*pod[^/]+\.slice)/(?:docker|cri-containerd|crio)-([0-9a-f]{64})\.scope$`),
+		Resolve: func(m []string) *Cgroup {
+			return &Cgroup{Driver: SystemdDriver, ContainerID: m[2], Parent: m[1]}
+		},
+	},
+	{
+		// kubelet with cgroupfs driver: /kubepods/burstable/pod<uid>/<id>
+		Name:    "kubernetes",
+		Pattern: regexp.MustCompile(`^(/kubepods(?:/[a-z]+)?/pod[0-9a-f-]+)/([0-9a-f]{64})$`),
+		Resolve: func(m []string) *Cgroup {
+			return &Cgroup{Driver: CgroupfsDriver, ContainerID: m[2], Parent: m[1]}
+		},
+	},
+	{
+		// docker with systemd driver, including rootless: /system.slice/docker-<id>.scope
+		// a scope can't have children, so sidecars can only be placed in the enclosing slice
+		Name:    "systemd",
+		Pattern: regexp.MustCompile(`^(.*/)?([^/]+\.slice)/docker-([0-9a-f]{64})\.scope$`),
+		Resolve: func(m []string) *Cgroup {
+			return &Cgroup{Driver: SystemdDriver, ContainerID: m[3], Parent: m[2]}
+		},
+	},
+	{
+		// docker with cgroupfs driver: /docker/<id>, possibly nested under a custom cgroup parent
+		Name:    "cgroupfs",
+		Pattern: regexp.MustCompile(`^((?:/.*)?/docker/([0-9a-f]{64}))$`),
+		Resolve: func(m []string) *Cgroup {
+			return &Cgroup{Driver: CgroupfsDriver, ContainerID: m[2], Parent: m[1]}
+		},
+	},
+}
+
+/**
+ Register an additional cgroup layout, checked before the built-in ones
+ */
+func RegisterCgroupLayout(layout CgroupLayout) {
+	cgroupLayouts = append([]CgroupLayout{layout}, cgroupLayouts...)
+}
+
+/**
+ Match a cgroup path against known layouts
+ */
+func ParseCgroupPath(p string) (*Cgroup, error) {
+	for _, l := range cgroupLayouts {
+		if m := l.Pattern.FindStringSubmatch(p); m != nil {
+			c := l.Resolve(m)
+			c.Layout = l.Name
+			return c, nil
+		}
+	}
+	return nil, errors.New("unsupported cgroup layout: " + p)
+}
+
+// systemd slices containers run in by default, shared with other services, so they don't carry our limits
+var sharedSlices = regexp.MustCompile(`^(-|system|user|machine|user-[0-9]+|app)\.slice$`)
+
+/**
+ Detect our own cgroup from /proc/self/cgroup content, supporting cgroup v1 and v2 (unified) hierarchies.
+ */
+func DetectCgroup(r io.Reader) (*Cgroup, error) {
+	p, err := readCgroupPath(r)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseCgroupPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.checkSlice()
+}
+
+/**
+ With systemd driver, limits are set on our container scope, which can't have children, so sidecars are placed in
+ the enclosing slice. It only carries our limits when container was given a dedicated one with `--cgroup-parent`.
+ */
+func (c *Cgroup) checkSlice() error {
+	if c.Driver != SystemdDriver || c.Layout == "kubernetes-systemd" || !sharedSlices.MatchString(c.Parent) {
+		return nil
+	}
+	return errors.Errorf("systemd slice %s is shared, sidecars placed there wouldn't get container limits. "+
+		"Run Lancelot with a dedicated --cgroup-parent slice, or set Lancelot --parent-cgroup", c.Parent)
+}
+
+/**
+ Read cgroup path from a /proc/<pid>/cgroup file, preferring pids controller then unified hierarchy
+ */
+func readCgroupPath(r io.Reader) (string, error) {
+	var unified, pids, any string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			unified = fields[2]
+		case contains(strings.Split(fields[1], ","), "pids"):
+			pids = fields[2]
+		case any == "":
+			any = fields[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	for _, p := range []string{pids, unified, any} {
+		if p == "" || p == "/" {
+			continue
+		}
+		return p, nil
+	}
+	// cgroup namespace hides the actual path, caller has to resolve it from the container ID
+	return "", errors.New("cgroup path is hidden by cgroup namespace")
+}
+
+var mountedContainer = regexp.MustCompile(`/containers/([0-9a-f]{64})/(?:hostname|resolv.conf|hosts)`)
+
+/**
+ Detect our container ID from /proc/self/mountinfo, which still tells it when cgroup namespace hides the cgroup path
+ */
+func DetectContainerID(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if m := mountedContainer.FindStringSubmatch(scanner.Text()); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", errors.New("not running inside a container")
+}
+
+/**
+ Detect our own cgroup, falling back to daemon inspection of our container when running in a private cgroup namespace
+ */
+func (p *Proxy) DetectCgroup() (*Cgroup, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := DetectCgroup(f)
+	if err == nil {
+		return c, nil
+	}
+	fmt.Println(err.Error())
+
+	m, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	id, err := DetectContainerID(m)
+	if err != nil {
+		return nil, err
+	}
+	return p.ContainerCgroup(id)
+}
+
+/**
+ Compute cgroup of a container from daemon configuration and container cgroup parent
+ */
+func (p *Proxy) ContainerCgroup(id string) (*Cgroup, error) {
+	info, err := p.client.Info(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	json, err := p.client.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	parent := ""
+	if json.HostConfig != nil {
+		parent = json.HostConfig.CgroupParent
+	}
+
+	c := &Cgroup{Layout: "daemon", Driver: info.CgroupDriver, ContainerID: json.ID}
+	if c.Driver == SystemdDriver {
+		if parent == "" {
+			parent = "system.slice"
+		}
+		c.Parent = parent
+		if err := c.checkSlice(); err != nil {
+			return nil, err
+		}
+	} else {
+		if parent == "" {
+			parent = "/docker"
+		}
+		c.Parent = path.Join(parent, json.ID)
+	}
+	return c, nil
+}
+
+/**
+ Cgroup explicitly set by configuration, as a path (cgroupfs driver) or a slice name (systemd driver)
+ */
+func CgroupOverride(parent string) *Cgroup {
+	if c, err := ParseCgroupPath(parent); err == nil {
+		return c
+	}
+	c := &Cgroup{Layout: "override", Driver: CgroupfsDriver, Parent: parent}
+	if strings.HasSuffix(parent, ".slice") {
+		c.Driver = SystemdDriver
+	}
+	return c
+}
+
+/**
+ Check a cgroup path is under this cgroup
+ */
+func (c *Cgroup) contains(p string) bool {
+	if c.Driver == SystemdDriver {
+		return p == c.Parent || strings.HasPrefix(p, c.Parent+"/") || strings.Contains(p, "/"+c.Parent+"/")
+	}
+	return p == c.Parent || strings.HasPrefix(p, c.Parent+"/")
+}
+
+/**
+ Check a container actually runs under our cgroup, and record placement in audit trail.
+ Effective cgroup is read from /proc when we share host PID namespace, and only then placement is verified. Otherwise
+ we can only check daemon kept the cgroup parent we set, placement is recorded as unverified.
+ */
+func (p *Proxy) checkPlacement(ctx context.Context, id string) error {
+	json, err := p.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	source := "daemon"
+	effective := ""
+	if json.HostConfig != nil {
+		effective = json.HostConfig.CgroupParent
+	}
+	if json.State != nil && json.State.Pid > 0 {
+		if f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", json.State.Pid)); err == nil {
+			// in a distinct PID namespace this pid is another process, only trust a path which refers to this container
+			if c, err := readCgroupPath(f); err == nil && strings.Contains(c, json.ID) {
+				source = "proc"
+				effective = c
+			}
+			f.Close()
+		}
+	}
+
+	placed := p.cgroup.contains(effective)
+	details := map[string]interface{}{
+		"expected": p.cgroup.Parent,
+		"source":   source,
+		"verified": source == "proc",
+		"placed":   placed,
+	}
+	if source == "proc" {
+		details["effective"] = effective
+	} else {
+		details["reported"] = effective
+	}
+	p.record("placement", json.ID, details)
+	if !placed {
+		return errors.Errorf("container %s runs in cgroup %s, not under %s", json.ID, effective, p.cgroup.Parent)
+	}
+	return nil
+}
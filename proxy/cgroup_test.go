@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+// container ID used in sample cgroup files
+const cgroupTestID = "3f4e8b1c0d2a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c"
+
+func TestDetectCgroup(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		proc   string // /proc/self/cgroup content
+		layout string
+		driver string
+		parent string
+		err    string
+	}{
+		{
+			name:   "v1 cgroupfs",
+			proc:   "12:pids:/docker/" + cgroupTestID + "\n11:memory:/docker/" + cgroupTestID + "\n1:name=systemd:/docker/" + cgroupTestID + "\n",
+			layout: "cgroupfs", driver: CgroupfsDriver, parent: "/docker/" + cgroupTestID,
+		},
+		{
+			name:   "v1 cgroupfs under custom parent",
+			proc:   "4:memory:/ci/build-42/docker/" + cgroupTestID + "\n3:pids:/ci/build-42/docker/" + cgroupTestID + "\n",
+			layout: "cgroupfs", driver: CgroupfsDriver, parent: "/ci/build-42/docker/" + cgroupTestID,
+		},
+		{
+			name:   "v2 cgroupfs",
+			proc:   "0::/docker/" + cgroupTestID + "\n",
+			layout: "cgroupfs", driver: CgroupfsDriver, parent: "/docker/" + cgroupTestID,
+		},
+		{
+			name:   "v2 systemd dedicated slice",
+			proc:   "0::/ci.slice/ci-build42.slice/docker-" + cgroupTestID + ".scope\n",
+			layout: "systemd", driver: SystemdDriver, parent: "ci-build42.slice",
+		},
+		{
+			name:   "v1 systemd dedicated slice",
+			proc:   "7:pids:/ci-build42.slice/docker-" + cgroupTestID + ".scope\n1:name=systemd:/ci-build42.slice/docker-" + cgroupTestID + ".scope\n",
+			layout: "systemd", driver: SystemdDriver, parent: "ci-build42.slice",
+		},
+		{
+			name: "v2 systemd default slice",
+			proc: "0::/system.slice/docker-" + cgroupTestID + ".scope\n",
+			err:  "systemd slice system.slice is shared",
+		},
+		{
+			name: "rootless systemd",
+			proc: "0::/user.slice/user-1000.slice/user@1000.service/user.slice/docker-" + cgroupTestID + ".scope\n",
+			err:  "systemd slice user.slice is shared",
+		},
+		{
+			name:   "kubepods cgroupfs",
+			proc:   "0::/kubepods/burstable/pod6a1e3c2f-8d7b-4e5a-9c3d-1f2e3d4c5b6a/" + cgroupTestID + "\n",
+			layout: "kubernetes", driver: CgroupfsDriver, parent: "/kubepods/burstable/pod6a1e3c2f-8d7b-4e5a-9c3d-1f2e3d4c5b6a",
+		},
+		{
+			name:   "kubepods systemd",
+			proc:   "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod6a1e3c2f_8d7b.slice/docker-" + cgroupTestID + ".scope\n",
+			layout: "kubernetes-systemd", driver: SystemdDriver, parent: "kubepods-burstable-pod6a1e3c2f_8d7b.slice",
+		},
+		{
+			name: "private cgroup namespace",
+			proc: "0::/\n",
+			err:  "hidden by cgroup namespace",
+		},
+		{
+			name: "not in a container",
+			proc: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			err:  "unsupported cgroup layout",
+		},
+	} {
+		c, err := DetectCgroup(strings.NewReader(test.proc))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if c.Layout != test.layout || c.Driver != test.driver || c.Parent != test.parent || c.ContainerID != cgroupTestID {
+			t.Errorf("%s: unexpected cgroup %+v", test.name, c)
+		}
+	}
+}
+
+func TestContainerCgroupInDefaultSlice(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.daemon.CgroupDriver = SystemdDriver
+
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	// started by hand, without cgroup parent
+	def, err := tp.daemon.ContainerCreate(context.Background(), &container.Config{Image: "busybox"}, &container.HostConfig{}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.proxy.ContainerCgroup(def.ID); err == nil || !strings.Contains(err.Error(), "systemd slice system.slice is shared") {
+		t.Fatalf("expected default slice to be refused, got %v", err)
+	}
+
+	ctr, err := tp.daemon.ContainerCreate(context.Background(), &container.Config{Image: "busybox"}, &container.HostConfig{CgroupParent: "ci-42.slice"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tp.proxy.ContainerCgroup(ctr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Parent != "ci-42.slice" {
+		t.Fatalf("expected sidecars in container slice, got %s", c.Parent)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// network modes which don't reference a tenant network
+var builtinNetworkModes = []string{"", "default", "bridge", "none"}
+
+/**
+ Forward container parameters compose relies on: labels (which compose uses to find project containers), healthcheck,
+ hostname, stop signal and networks owned by tenant. Returns networking config to forward, networks being referenced
+ by client names until daemonNetworks translates them.
+ */
+func (p *Proxy) composeContainer(config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig,
+	forwardedConfig *container.Config, forwardedHostConfig *container.HostConfig) (*network.NetworkingConfig, error) {
+
+	labels := map[string]string{}
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	for k, v := range forwardedConfig.Labels {
+		labels[k] = v
+	}
+	forwardedConfig.Labels = labels
+	forwardedConfig.Healthcheck = config.Healthcheck
+	forwardedConfig.Hostname = config.Hostname
+	forwardedConfig.Domainname = config.Domainname
+	forwardedConfig.ExposedPorts = config.ExposedPorts
+	forwardedConfig.OpenStdin = config.OpenStdin
+	forwardedConfig.StdinOnce = config.StdinOnce
+	forwardedConfig.StopSignal = config.StopSignal
+	forwardedConfig.StopTimeout = config.StopTimeout
+
+	mode := string(hostConfig.NetworkMode)
+	switch {
+	case contains(builtinNetworkModes, mode):
+	case strings.HasPrefix(mode, "container:"):
+		// `network_mode: service:db`
+		id, err := p.ownsContainer(strings.TrimPrefix(mode, "container:"))
+		if err != nil {
+			return nil, err
+		}
+		mode = "container:" + id
+	case mode == "host":
+		return nil, denied("host network is not authorized")
+	default:
+		if _, err := p.ownsNetwork(mode); err != nil {
+			return nil, err
+		}
+	}
+	forwardedHostConfig.NetworkMode = container.NetworkMode(mode)
+
+	if networkingConfig == nil {
+		return nil, nil
+	}
+	forwarded := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	for name, e := range networkingConfig.EndpointsConfig {
+		if !contains(builtinNetworkModes, name) {
+			if _, err := p.ownsNetwork(name); err != nil {
+				return nil, err
+			}
+		}
+		// aliases make services reachable by name, anything else is about addressing we don't let tenant pick
+		endpoint := &network.EndpointSettings{}
+		if e != nil {
+			endpoint.Aliases = e.Aliases
+		}
+		forwarded.EndpointsConfig[name] = endpoint
+	}
+	return forwarded, nil
+}
+
+/**
+ Translate network names referenced by container to the ones known by daemon. Networks which are neither builtin
+ nor owned by tenant are reported as not found.
+ */
+func (p *Proxy) daemonNetworks(hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) error {
+	if mode := string(hostConfig.NetworkMode); !contains(builtinNetworkModes, mode) && !strings.HasPrefix(mode, "container:") {
+		name, err := p.ownsNetwork(mode)
+		if err != nil {
+			return err
+		}
+		hostConfig.NetworkMode = container.NetworkMode(name)
+	}
+	if networkingConfig == nil {
+		return nil
+	}
+	endpoints := map[string]*network.EndpointSettings{}
+	for name, e := range networkingConfig.EndpointsConfig {
+		if !contains(builtinNetworkModes, name) {
+			var err error
+			if name, err = p.ownsNetwork(name); err != nil {
+				return err
+			}
+		}
+		endpoints[name] = e
+	}
+	networkingConfig.EndpointsConfig = endpoints
+	return nil
+}
@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// composeService is a service of a sample compose project
+type composeService struct {
+	name      string
+	image     string
+	healthy   bool     // has a healthcheck
+	dependsOn []string // services which have to be healthy first
+	binds     []string
+}
+
+// composeProject drives proxy with the API calls docker-compose makes, using the same docker client
+type composeProject struct {
+	t    *testing.T
+	cli  *client.Client
+	name string
+}
+
+func newComposeProject(t *testing.T, tp *testProxy, name string) *composeProject {
+	cli, err := client.NewClient(strings.Replace(tp.server.URL, "http://", "tcp://", 1), "1.31", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &composeProject{t: t, cli: cli, name: name}
+}
+
+func (c *composeProject) labels(service string) map[string]string {
+	return map[string]string{
+		"com.docker.compose.project": c.name,
+		"com.docker.compose.service": service,
+		"com.docker.compose.oneoff":  "False",
+	}
+}
+
+func (c *composeProject) filter(service string) filters.Args {
+	f := filters.NewArgs()
+	f.Add("label", "com.docker.compose.project="+c.name)
+	if service != "" {
+		f.Add("label", "com.docker.compose.service="+service)
+		f.Add("label", "com.docker.compose.oneoff=False")
+	}
+	return f
+}
+
+/**
+ `docker-compose up -d`
+ */
+func (c *composeProject) up(services ...composeService) error {
+	ctx := context.Background()
+	if _, err := c.cli.ServerVersion(ctx); err != nil {
+		return err
+	}
+
+	net := c.name + "_default"
+	if _, err := c.cli.NetworkInspect(ctx, net, types.NetworkInspectOptions{}); client.IsErrNotFound(err) {
+		_, err = c.cli.NetworkCreate(ctx, net, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			Labels:         map[string]string{"com.docker.compose.project": c.name, "com.docker.compose.network": "default"},
+		})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, s := range services {
+		for _, b := range s.binds {
+			volume := strings.Split(b, ":")[0]
+			if _, err := c.cli.VolumeInspect(ctx, volume); client.IsErrNotFound(err) {
+				_, err = c.cli.VolumeCreate(ctx, volumetypes.VolumesCreateBody{
+					Name:   volume,
+					Labels: map[string]string{"com.docker.compose.project": c.name},
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		existing, err := c.cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: c.filter(s.name)})
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+
+		for _, d := range s.dependsOn {
+			if err := c.waitHealthy(d); err != nil {
+				return err
+			}
+		}
+
+		if _, _, err := c.cli.ImageInspectWithRaw(ctx, s.image); client.IsErrNotFound(err) {
+			pull, err := c.cli.ImagePull(ctx, s.image, types.ImagePullOptions{})
+			if err != nil {
+				return err
+			}
+			ioutil.ReadAll(pull)
+			pull.Close()
+		}
+
+		config := &container.Config{Image: s.image, Labels: c.labels(s.name), Hostname: s.name}
+		if s.healthy {
+			config.Healthcheck = &container.HealthConfig{Test: []string{"CMD-SHELL", "true"}, Interval: time.Second}
+		}
+		created, err := c.cli.ContainerCreate(ctx, config,
+			&container.HostConfig{NetworkMode: container.NetworkMode(net), Binds: s.binds},
+			&network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+				net: {Aliases: []string{s.name}},
+			}},
+			c.name+"_"+s.name+"_1")
+		if err != nil {
+			return err
+		}
+		if len(created.Warnings) > 0 {
+			c.t.Errorf("unexpected warnings creating %s: %v", s.name, created.Warnings)
+		}
+		if err := c.cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *composeProject) waitHealthy(service string) error {
+	containers, err := c.cli.ContainerList(context.Background(), types.ContainerListOptions{Filters: c.filter(service)})
+	if err != nil {
+		return err
+	}
+	for _, ctr := range containers {
+		json, err := c.cli.ContainerInspect(context.Background(), ctr.ID)
+		if err != nil {
+			return err
+		}
+		if json.State.Health == nil || json.State.Health.Status != types.Healthy {
+			c.t.Fatalf("service %s is not healthy", service)
+		}
+	}
+	return nil
+}
+
+/**
+ `docker-compose ps`, returning container names by service
+ */
+func (c *composeProject) ps() map[string]string {
+	containers, err := c.cli.ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: c.filter("")})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	names := map[string]string{}
+	for _, ctr := range containers {
+		names[ctr.Labels["com.docker.compose.service"]] = ctr.Names[0]
+	}
+	return names
+}
+
+/**
+ `docker-compose down -v`
+ */
+func (c *composeProject) down() error {
+	ctx := context.Background()
+	containers, err := c.cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: c.filter("")})
+	if err != nil {
+		return err
+	}
+	timeout := 10 * time.Second
+	for _, ctr := range containers {
+		if err := c.cli.ContainerStop(ctx, ctr.ID, &timeout); err != nil {
+			return err
+		}
+		wait, errs := c.cli.ContainerWait(ctx, ctr.ID, container.WaitConditionNotRunning)
+		select {
+		case <-wait:
+		case err := <-errs:
+			return err
+		}
+		if err := c.cli.ContainerRemove(ctx, ctr.ID, types.ContainerRemoveOptions{RemoveVolumes: true}); err != nil {
+			return err
+		}
+	}
+	networks, err := c.cli.NetworkList(ctx, types.NetworkListOptions{Filters: c.filter("")})
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if err := c.cli.NetworkRemove(ctx, n.ID); err != nil {
+			return err
+		}
+	}
+	volumes, err := c.cli.VolumeList(ctx, c.filter(""))
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes.Volumes {
+		if err := c.cli.VolumeRemove(ctx, v.Name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestComposeProject(t *testing.T) {
+	tp := newTestProxy(t, Policy{Profile: ComposeProfile, Strict: true})
+	defer tp.Close()
+	tp.proxy.SetTenant("ci-42")
+
+	project := newComposeProject(t, tp, "app")
+	services := []composeService{
+		{name: "db", image: "busybox", healthy: true, binds: []string{"app_data:/var/lib/db"}},
+		{name: "web", image: "busybox", dependsOn: []string{"db"}},
+	}
+	if err := project.up(services...); err != nil {
+		t.Fatal(err)
+	}
+	// up is idempotent
+	if err := project.up(services...); err != nil {
+		t.Fatal(err)
+	}
+
+	names := project.ps()
+	if len(names) != 2 || names["db"] != "/app_db_1" || names["web"] != "/app_web_1" {
+		t.Fatalf("unexpected project containers %v", names)
+	}
+
+	// services share project network, on which they are reachable by service name
+	n, err := project.cli.NetworkInspect(context.Background(), "app_default", types.NetworkInspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != "app_default" || len(n.Containers) != 2 {
+		t.Fatalf("expected both services on project network, got %s with %v", n.Name, n.Containers)
+	}
+	web, err := tp.daemon.ContainerInspect(context.Background(), "ci-42_app_web_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := web.NetworkSettings.Networks["ci-42_app_default"]; e == nil || len(e.Aliases) != 1 || e.Aliases[0] != "web" {
+		t.Fatalf("expected web to be attached to namespaced project network, got %v", web.NetworkSettings.Networks)
+	}
+
+	if err := project.down(); err != nil {
+		t.Fatal(err)
+	}
+	if names := project.ps(); len(names) != 0 {
+		t.Fatalf("expected project to be down, got %v", names)
+	}
+	if _, err := tp.daemon.NetworkInspect(context.Background(), "ci-42_app_default", types.NetworkInspectOptions{}); err == nil {
+		t.Fatal("expected project network to be removed")
+	}
+}
+
+func TestComposeProfileKeepsRestrictions(t *testing.T) {
+	tp := newTestProxy(t, Policy{Profile: ComposeProfile})
+	defer tp.Close()
+
+	tp.expectError(tp.do("POST", "/networks/create", map[string]interface{}{"Name": "overlay", "Driver": "overlay"}), http.StatusForbidden, "network driver overlay is not authorized")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"NetworkMode": "host"},
+	}), http.StatusForbidden, "host network is not authorized")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/etc:/host/etc"}},
+	}), http.StatusForbidden, "bind mount are not authorized")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"NetworkMode": "bridge"},
+		"NetworkingConfig": map[string]interface{}{"EndpointsConfig": map[string]interface{}{"foreign": map[string]interface{}{}}},
+	}), http.StatusNotFound, "network foreign not found")
+
+	body := container.ContainerCreateCreatedBody{}
+	tp.decode(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Privileged": true},
+	}), http.StatusCreated, &body)
+	if len(body.Warnings) != 1 || !strings.Contains(body.Warnings[0], "HostConfig.Privileged") {
+		t.Fatalf("expected privileged to be dropped, got %v", body.Warnings)
+	}
+}
+
+func TestNetworksRequireComposeProfile(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expectError(tp.do("POST", "/networks/create", map[string]interface{}{"Name": "net"}), http.StatusNotFound, "only supported by Lancelot with compose profile")
+}
+
+func TestForeignNetworksCantBeJoined(t *testing.T) {
+	for _, policy := range []Policy{{}, {Profile: ComposeProfile}} {
+		tp := newTestProxy(t, policy)
+		foreign, err := tp.daemon.NetworkCreate(context.Background(), "other-tenant_net", types.NetworkCreate{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, network := range []string{"other-tenant_net", foreign.ID} {
+			tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+				"Image":            "busybox",
+				"NetworkingConfig": map[string]interface{}{"EndpointsConfig": map[string]interface{}{network: map[string]interface{}{}}},
+			}), http.StatusNotFound, "network "+network+" not found")
+		}
+		if policy.Profile == ComposeProfile {
+			// network mode is ignored otherwise
+			tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+				"Image":      "busybox",
+				"HostConfig": map[string]interface{}{"NetworkMode": "other-tenant_net"},
+			}), http.StatusNotFound, "network other-tenant_net not found")
+		}
+		tp.Close()
+	}
+}
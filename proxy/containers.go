@@ -3,36 +3,32 @@ package proxy
 import (
 	"fmt"
 	"net/http"
-	"golang.org/x/net/context"
 	"github.com/docker/docker/api/server/httputils"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/runconfig"
 	"github.com/gorilla/mux"
 	"encoding/json"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"io"
 	"strconv"
-	"net"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/filters"
 	"time"
 	"github.com/docker/docker/pkg/ioutils"
 	"encoding/base64"
+	"strings"
 )
 
 
 func (p *Proxy) containerList(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	filter, err := filters.FromParam(r.Form.Get("filters"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -44,10 +40,10 @@ func (p *Proxy) containerList(w http.ResponseWriter, r *http.Request) {
 		Filters: filter,
 	}
 
-	containers, err := p.client.ContainerList(context.Background(), config)
+	config.Filters = p.nameFilter(config.Filters)
+	containers, err := p.client.ContainerList(r.Context(), config)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -56,6 +52,7 @@ func (p *Proxy) containerList(w http.ResponseWriter, r *http.Request) {
 	for _, c := range containers {
 		_, err := p.ownsContainer(c.ID);
 		if err == nil {
+			p.clientContainer(&c)
 			mine = append(mine, c)
 		}
 	}
@@ -68,22 +65,16 @@ func (p *Proxy) containerInspect(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	json, err := p.client.ContainerInspect(context.Background(), name)
+	json, err := p.client.ContainerInspect(r.Context(), name)
 	if err != nil {
-		if client.IsErrContainerNotFound(err) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		} else {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		writeError(w, r, err)
+		return
 	}
+	p.clientContainerJSON(&json)
 
 	httputils.WriteJSON(w, http.StatusOK, json) // TODO we could filter container by label to hide container created by another client
 }
@@ -92,64 +83,145 @@ func (p *Proxy) containerInspect(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) containerCreate(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if err := httputils.CheckForJSON(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	name := r.Form.Get("name")
+	name := p.daemonName(r.Form.Get("name"))
 
 	decoder := runconfig.ContainerDecoder{}
 	config, hostConfig, networkingConfig, err := decoder.DecodeConfig(r.Body)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequest("%s", err.Error()))
+		return
+	}
+
+	if hostConfig == nil {
+		// docker CLI always sends one, but other API clients might not
+		hostConfig = &container.HostConfig{}
+	}
+
+	// host paths can only be bound when allowed by policy, or from a volume caller mounted, like its workspace
+	workspace, err := p.workspaceVolumes(r.Context(), hostConfig)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Binds is the old API
-	binds := hostConfig.Binds
-	for _, b := range binds {
+	binds := []string{}
+	for _, b := range hostConfig.Binds {
 		if b[:1] == "/" {
-			http.Error(w, "Bind mount are not authorized", http.StatusUnauthorized)
-			return
+			bind, allowed, err := p.hostBind(b)
+			if !allowed {
+				bind, err = p.workspaceBind(workspace, b)
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			// subdirectories of a caller volume share the whole volume
+			if !contains(binds, bind) {
+				binds = append(binds, bind)
+			}
+			continue
 		}
+		// named volume, as `name:/path[:mode]`
+		volume := strings.SplitN(b, ":", 2)
+		volume[0] = p.daemonVolume(volume[0])
+		binds = append(binds, strings.Join(volume, ":"))
 	}
 
 	// Mounts is the new API with explicit types
-	mounts := hostConfig.Mounts
-	for _, m := range mounts {
+	mounts := []mount.Mount{}
+	for _, m := range hostConfig.Mounts {
 		if m.Type == mount.TypeBind {
-			http.Error(w, "Bind mount are not authorized", http.StatusUnauthorized)
-			return
+			host, allowed, err := p.hostMount(m)
+			if allowed {
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+				mounts = append(mounts, host)
+				continue
+			}
+			translated, err := p.workspaceMount(workspace, m.Source, m.Target)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			m.Type, m.Source, m.Target, m.BindOptions = translated.Type, translated.Source, translated.Target, nil
+			m.ReadOnly = m.ReadOnly || translated.ReadOnly
+			if !sharesMount(mounts, m) {
+				mounts = append(mounts, m)
+			}
+			continue
+		}
+		if m.Type == mount.TypeVolume && m.VolumeOptions != nil && m.VolumeOptions.DriverConfig != nil {
+			// volume is created with these options, which could bind mount a host path
+			if err := checkVolumeDriver(m.VolumeOptions.DriverConfig.Name, m.VolumeOptions.DriverConfig.Options, p.policy.Storage.tmpfsSize()); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+		if m.Type == mount.TypeVolume && m.Source != "" {
+			m.Source = p.daemonVolume(m.Source)
 		}
+		mounts = append(mounts, m)
 	}
 
 	volumesFrom := []string{}
 	for _, c := range hostConfig.VolumesFrom {
-		id, err := p.ownsContainer(c)
+		// as `container[:mode]`
+		from := strings.SplitN(c, ":", 2)
+		id, err := p.ownsContainer(from[0])
 		if err != nil {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			writeError(w, r, err)
 			return
 		}
-		volumesFrom = append(volumesFrom, id)
+		from[0] = id
+		volumesFrom = append(volumesFrom, strings.Join(from, ":"))
 	}
 
 	links := []string{}
 	for _, c := range hostConfig.Links {
-		id, err := p.ownsContainer(c)
-		if err != nil && c != p.GetHostname() {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		// as `container[:alias]`, alias being container name by default
+		link := strings.SplitN(c, ":", 2)
+		if len(link) == 1 {
+			link = append(link, strings.TrimPrefix(link[0], "/"))
+		}
+		if link[0] == p.GetHostname() {
+			links = append(links, c)
+			continue
+		}
+		id, err := p.ownsContainer(link[0])
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
-		links = append(links, id)
+		links = append(links, id+":"+link[1])
+	}
+
+	env, err := p.injectEnv(config.Env)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	quotas := []string{ContainersQuota}
+	if len(config.Volumes) + len(binds) + len(mounts) > 0 {
+		quotas = append(quotas, VolumesQuota, VolumesSizeQuota)
+	}
+	if p.isNewImage(config.Image) {
+		quotas = append(quotas, ImagesQuota, ImagesSizeQuota)
+	}
+	if err := p.checkQuota(r.Context(), quotas...); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	auth := r.Header.Get("X-Registry-Auth")
@@ -158,24 +230,23 @@ func (p *Proxy) containerCreate(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Checking legitimate access to image '%s' with credentials: %s\n", config.Image, auth);
 
 		// We need to pull the image from registry to check client authentication let him access it
-		load, err := p.client.ImagePull(context.Background(), config.Image, types.ImagePullOptions{
+		load, err := p.client.ImagePull(r.Context(), config.Image, types.ImagePullOptions{
 			All: false,
 			RegistryAuth: auth,
 		})
-		// we pull to check permission, not to actually update image
-		load.Close()
 		if err != nil {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
+		// we pull to check permission, not to actually update image
+		load.Close()
 		p.addImage(config.Image)
 	}
 
-	body, err := p.client.ContainerCreate(context.Background(), &container.Config {
+	forwardedConfig := &container.Config {
 		Tty: config.Tty,
 		User: config.User, // block user = root ?
-		Env: config.Env,
+		Env: env,
 		Cmd: config.Cmd,
 		AttachStdout: config.AttachStdout,
 		AttachStdin: config.AttachStdin,
@@ -185,36 +256,80 @@ func (p *Proxy) containerCreate(w http.ResponseWriter, r *http.Request) {
 		Image: config.Image,
 		Volumes: config.Volumes,
 		WorkingDir: config.WorkingDir,
-	}, &container.HostConfig{
+		Labels: retentionLabels(p.policy.Teardown.Containers, nil),
+	}
+	forwardedHostConfig := &container.HostConfig{
 		Privileged: false,
 		AutoRemove: hostConfig.AutoRemove,
 		Binds: binds,
 		Mounts: mounts,
 		Links: links,
 		VolumesFrom: volumesFrom,
-		Cgroup: container.CgroupSpec(p.GetCgroup()), // Force container to run within the same CGroup
-	}, networkingConfig, name)
-	if err != nil {
-		if client.IsErrImageNotFound(err) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		CgroupParent: p.GetCgroup(), // Force container to run within the same CGroup
+	}
+	storageWarnings, err := p.storageLimits(r.Context(), hostConfig, forwardedHostConfig)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	requestedNetworkingConfig := networkingConfig
+	if p.policy.Profile == ComposeProfile {
+		networkingConfig, err = p.composeContainer(config, hostConfig, networkingConfig, forwardedConfig, forwardedHostConfig)
+		if err != nil {
+			writeError(w, r, err)
+			return
 		}
+	}
+	// set after compose profile merged client labels
+	forwardedConfig.Labels = p.injectLabels(forwardedConfig.Labels)
+	if p.pod != nil {
+		// join pod sandbox, so sidecar is a peer of containers declared in pod. Links are useless then.
+		forwardedHostConfig.NetworkMode = container.NetworkMode("container:" + p.pod.Sandbox)
+		forwardedHostConfig.IpcMode = container.IpcMode("container:" + p.pod.Sandbox)
+		forwardedHostConfig.Links = nil
+		networkingConfig = nil
+	}
+
+	// report parameters we don't support, rather than silently ignoring them
+	unsupported := unsupportedFields("Config", config, forwardedConfig)
+	unsupported = append(unsupported, unsupportedFields("HostConfig", hostConfig, forwardedHostConfig)...)
+	unsupported = append(unsupported, unsupportedFields("NetworkingConfig", requestedNetworkingConfig, networkingConfig)...)
+	if len(unsupported) > 0 && p.policy.Strict {
+		writeError(w, r, badRequest("Unsupported parameters: %s", strings.Join(unsupported, ", ")))
+		return
+	}
+	warnings := storageWarnings
+	for _, u := range unsupported {
+		warnings = append(warnings, u+" is not supported by Lancelot and has been ignored")
+	}
+	if err := p.daemonNetworks(forwardedHostConfig, networkingConfig); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := p.client.ContainerCreate(r.Context(), forwardedConfig, forwardedHostConfig, networkingConfig, name)
+	if err != nil {
+		writeError(w, r, p.clientError(err))
+		return
+	}
+
+	if err := p.checkPlacement(r.Context(), body.ID); err != nil {
+		fmt.Println(err.Error())
+		p.client.ContainerRemove(r.Context(), body.ID, types.ContainerRemoveOptions{Force: true})
+		writeError(w, r, err)
 		return
 	}
 
 	p.addContainer(body.ID)
+	p.recordRoot(body.ID)
 	if name != "" {
-		// FIXME as we support named containers there's a risk for name collision
-		// maybe force a prefix for names ?
-		p.addContainer(name);
+		// names are namespaced by tenant, so they don't collide with other tenants' ones
+		p.addContainer(strings.TrimPrefix(name, "/"))
 	}
 
-	json, err := p.client.ContainerInspect(context.Background(), body.ID)
-	if err := httputils.CheckForJSON(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	json, err := p.client.ContainerInspect(r.Context(), body.ID)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	for _, m := range json.Mounts {
@@ -223,8 +338,9 @@ func (p *Proxy) containerCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	httputils.WriteJSON(w, http.StatusCreated, &types.IDResponse{
+	httputils.WriteJSON(w, http.StatusCreated, &container.ContainerCreateCreatedBody{
 		ID: body.ID,
+		Warnings: append(body.Warnings, warnings...),
 	})
 }
 
@@ -232,13 +348,29 @@ func (p *Proxy) containerStart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
+		return
+	}
+
+	if err := p.checkQuota(r.Context(), RunningContainersQuota); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	p.client.ContainerStart(context.Background(), name, types.ContainerStartOptions{
+	err = p.client.ContainerStart(r.Context(), name, types.ContainerStartOptions{
 	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// cgroup parent might have been ignored by daemon, don't let such a container consume resources out of our limits
+	if err := p.checkPlacement(r.Context(), name); err != nil {
+		fmt.Println(err.Error())
+		p.client.ContainerKill(r.Context(), name, "KILL")
+		writeError(w, r, err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -247,19 +379,17 @@ func (p *Proxy) containerAttach(w http.ResponseWriter, r *http.Request) {
 
 	err := httputils.ParseForm(r)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	hijack, err := p.client.ContainerAttach(context.Background(), name, types.ContainerAttachOptions{
+	hijack, err := p.client.ContainerAttach(r.Context(), name, types.ContainerAttachOptions{
 		Stdin:   httputils.BoolValue(r, "stdin"),
 		Stdout:  httputils.BoolValue(r, "stdout"),
 		Stderr:  httputils.BoolValue(r, "stderr"),
@@ -268,88 +398,64 @@ func (p *Proxy) containerAttach(w http.ResponseWriter, r *http.Request) {
 		DetachKeys: r.FormValue("detachKeys"),
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	p.hijack(w, hijack, r)
+	p.hijack(w, hijack, r, name)
 }
 
-func (p *Proxy) hijack(w http.ResponseWriter, h types.HijackedResponse, r *http.Request) {
+/**
+ Take over client connection to relay a stream attached to container or exec process, till it ends
+ */
+func (p *Proxy) hijack(w http.ResponseWriter, h types.HijackedResponse, r *http.Request, resource string) {
 
-	stdin, stdout, err := httputils.HijackConnection(w)
+	conn, rw, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		fmt.Println(err.Error())
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.Close()
+		writeError(w, r, err)
 		return
 	}
 
 	_, upgrade := r.Header["Upgrade"]
 	if upgrade {
-		fmt.Fprintf(stdout, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
 	} else {
-		fmt.Fprintf(stdout, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
 	}
 
-	// Pipe
-	go func() {
-		defer h.Close()
-		defer stdout.(net.Conn).Close()
-		b, err := io.Copy(stdout, h.Reader)
-		if err != nil {
-			fmt.Println("ouch...")
-			fmt.Println(err.Error())
-		}
-		fmt.Printf("End of stdout, %d bytes written\n", b)
-	}()
-
-	go func() {
-		defer stdin.(net.Conn).Close()
-		b, err := io.Copy(h.Conn, stdin)
-		if err != nil {
-			fmt.Println("ouch...")
-			fmt.Println(err.Error())
-		}
-		fmt.Printf("End of stdout, %d bytes written\n", b)
-	}()
+	p.relay(r.Context(), resource, conn, rw.Reader, h)
 }
 
 func (p *Proxy) containerResize(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	height, err := strconv.Atoi(r.Form.Get("h"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	width, err := strconv.Atoi(r.Form.Get("w"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	err = p.client.ContainerResize(context.Background(), name, types.ResizeOptions{
+	err = p.client.ContainerResize(r.Context(), name, types.ResizeOptions{
 		Height: uint(height),
 		Width: uint(width),
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -361,19 +467,17 @@ func (p *Proxy) containerLogs(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	stdout, stderr := httputils.BoolValue(r, "stdout"), httputils.BoolValue(r, "stderr")
 	if !(stdout || stderr) {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequest("Bad parameters: you must choose at least one stream"))
 		return
 	}
 
-	reader, err := p.client.ContainerLogs(context.Background(), name, types.ContainerLogsOptions{
+	reader, err := p.client.ContainerLogs(r.Context(), name, types.ContainerLogsOptions{
 		ShowStdout: stdout,
 		ShowStderr: stderr,
 		Since:      r.Form.Get("since"),
@@ -384,8 +488,7 @@ func (p *Proxy) containerLogs(w http.ResponseWriter, r *http.Request) {
 	});
 
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -398,15 +501,13 @@ func (p *Proxy) containerStop(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -414,14 +515,16 @@ func (p *Proxy) containerStop(w http.ResponseWriter, r *http.Request) {
 	if tmpSeconds := r.Form.Get("t"); tmpSeconds != "" {
 		valSeconds, err := strconv.Atoi(tmpSeconds)
 		if err != nil {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 		seconds = time.Duration(valSeconds)
 	}
 
-	p.client.ContainerStop(context.Background(), name, &seconds)
+	if err := p.client.ContainerStop(r.Context(), name, &seconds); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -431,14 +534,20 @@ func (p *Proxy) containerKill(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
+	if err := httputils.ParseForm(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
 	signal := r.Form.Get("signal")
 
-	p.client.ContainerKill(context.Background(), name, signal)
+	if err := p.client.ContainerKill(r.Context(), name, signal); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -447,39 +556,43 @@ func (p *Proxy) containerExecCreate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"]);
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	if err := httputils.CheckForJSON(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	execConfig := &types.ExecConfig{}
 	if err := json.NewDecoder(r.Body).Decode(execConfig); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	names := []string{}
+	for _, e := range execConfig.Env {
+		names = append(names, strings.SplitN(e, "=", 2)[0])
+	}
+	if err := p.checkEnv(names...); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	if len(execConfig.Cmd) == 0 {
-		http.Error(w, "No exec command specified", http.StatusBadRequest)
+		writeError(w, r, badRequest("No exec command specified"))
 		return
 	}
 
 	// Register an instance of Exec in container.
-	id, err := p.client.ContainerExecCreate(context.Background(), name, *execConfig)
+	id, err := p.client.ContainerExecCreate(r.Context(), name, *execConfig)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -494,34 +607,31 @@ func (p *Proxy) containerExecResize(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	execId := vars["execId"]
 	if !p.ownsExec(execId) {
-		http.Error(w, "You don't own " + execId, http.StatusUnauthorized)
+		writeError(w, r, notFound("No such exec instance: %s", execId))
+		return
 	}
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	height, err := strconv.Atoi(r.Form.Get("h"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	width, err := strconv.Atoi(r.Form.Get("w"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	err = p.client.ContainerExecResize(context.Background(), execId, types.ResizeOptions{
+	err = p.client.ContainerExecResize(r.Context(), execId, types.ResizeOptions{
 		Height: uint(height),
 		Width: uint(width),
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -531,34 +641,33 @@ func (p *Proxy) containerExecResize(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) containerExecStart(w http.ResponseWriter, r *http.Request) {
 	execId := mux.Vars(r)["execId"]
 	if !p.ownsExec(execId) {
-		http.Error(w, "You don't own " + execId, http.StatusUnauthorized)
+		writeError(w, r, notFound("No such exec instance: %s", execId))
+		return
 	}
 
 	execStartCheck := &types.ExecStartCheck{}
 	if err := json.NewDecoder(r.Body).Decode(execStartCheck); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 
 	if execStartCheck.Detach {
-		http.Error(w, "sorry we only support attached mode", http.StatusInternalServerError)
+		writeError(w, r, badRequest("Detached exec is not supported by Lancelot"))
 		return
 	}
 
-	hijack, err := p.client.ContainerExecAttach(context.Background(), execId, types.ExecConfig{
+	hijack, err := p.client.ContainerExecAttach(r.Context(), execId, types.ExecConfig{
 		AttachStdin: httputils.BoolValue(r, "stdin"),
 		AttachStdout: httputils.BoolValue(r, "stdout"),
 		AttachStderr: httputils.BoolValue(r, "stderr"),
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	p.hijack(w, hijack, r)
+	p.hijack(w, hijack, r, execId)
 }
 
 
@@ -566,19 +675,14 @@ func (p *Proxy) execInspect(w http.ResponseWriter, r *http.Request) {
 
 	execId := mux.Vars(r)["execId"]
 	if !p.ownsExec(execId) {
-		http.Error(w, "You don't own " + execId, http.StatusUnauthorized)
+		writeError(w, r, notFound("No such exec instance: %s", execId))
+		return
 	}
 
-	json, err := p.client.ContainerExecInspect(context.Background(), execId)
+	json, err := p.client.ContainerExecInspect(r.Context(), execId)
 	if err != nil {
-		if client.IsErrContainerNotFound(err) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		} else {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		writeError(w, r, err)
+		return
 	}
 
 	httputils.WriteJSON(w, http.StatusOK, json) // TODO we could filter container by label to hide container created by another client
@@ -588,53 +692,124 @@ func (p *Proxy) containerDelete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"])
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
-	p.client.ContainerRemove(context.Background(), name, types.ContainerRemoveOptions{
+	err = p.client.ContainerRemove(r.Context(), name, types.ContainerRemoveOptions{
 		Force: httputils.BoolValue(r, "force"),
 		RemoveVolumes: httputils.BoolValue(r, "v"),
 		RemoveLinks: httputils.BoolValue(r, "link"),
 	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 func (p *Proxy) containerArchiveGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"])
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	v, err := httputils.ArchiveFormValues(r, vars)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	reader, stat, err := p.client.CopyFromContainer(context.Background(), name, v.Path)
+	stat, err := p.client.ContainerStatPath(r.Context(), name, v.Path)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	if err := p.checkDownload(r.Context(), name, v.Path, stat); err != nil {
+		p.record("download", name, map[string]interface{}{"path": v.Path, "denied": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
-	statJSON, err := json.Marshal(stat)
+	reader, stat, err := p.client.CopyFromContainer(r.Context(), name, v.Path)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	defer reader.Close()
 
+	if err := writePathStat(w, stat); err != nil {
+		writeError(w, r, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/x-tar")
-	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(statJSON))
 
+	// a directory size is only known once streamed, so download is aborted when over limit
+	limit := p.policy.Archive.maxDownloadSize()
 	output := ioutils.NewWriteFlusher(w)
 	defer output.Close()
-	io.Copy(output, reader)
+	n, _ := io.Copy(output, io.LimitReader(reader, limit))
+	if n == limit {
+		if more, _ := reader.Read(make([]byte, 1)); more > 0 {
+			p.abortDownload(w, name, v.Path, n)
+			return
+		}
+	}
+	p.record("download", name, map[string]interface{}{"path": v.Path, "bytes": n})
+}
+
+/**
+ Close client connection before the end of a download over limit, so client gets an error rather than an archive
+ which looks complete
+ */
+func (p *Proxy) abortDownload(w http.ResponseWriter, container, path string, n int64) {
+	fmt.Printf("download of %s from container %s aborted at %d bytes\n", path, container, n)
+	p.record("download", container, map[string]interface{}{"path": path, "bytes": n, "aborted": true})
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	conn.Close()
+}
+
+func (p *Proxy) containerArchiveHead(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, err := p.ownsContainer(vars["name"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	v, err := httputils.ArchiveFormValues(r, vars)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stat, err := p.client.ContainerStatPath(r.Context(), name, v.Path)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := writePathStat(w, stat); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/**
+ Set path stat header, as `docker cp` expects it
+ */
+func writePathStat(w http.ResponseWriter, stat types.ContainerPathStat) error {
+	statJSON, err := json.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(statJSON))
+	return nil
 }
 
 func (p *Proxy) containerArchivePut(w http.ResponseWriter, r *http.Request) {
@@ -642,26 +817,55 @@ func (p *Proxy) containerArchivePut(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name, err := p.ownsContainer(vars["name"])
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
 	v, err := httputils.ArchiveFormValues(r, vars)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	err = p.client.CopyToContainer(context.Background(), name, v.Path, r.Body, types.CopyToContainerOptions{
-		AllowOverwriteDirWithFile: httputils.BoolValue(r, "noOverwriteDirNonDir"),
+	inspector := &archiveInspector{dest: v.Path, limit: p.policy.Archive.maxSize()}
+	if len(p.policy.Archive.Paths) > 0 {
+		json, err := p.client.ContainerInspect(r.Context(), name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if inspector.paths, err = p.containerPaths(r.Context(), p.policy.Archive.Paths, json); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if inspector.paths != nil {
+		// daemon extracts archive where destination resolves to
+		link, out, err := p.linkOut(r.Context(), name, v.Path, inspector.paths)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if link != "" {
+			writeError(w, r, denied("%s is not an authorized destination, %s links to %s", v.Path, link, out))
+			return
+		}
+	}
+
+	// archive is checked while streamed to daemon
+	checked := inspector.inspect(r.Body)
+	err = p.client.CopyToContainer(r.Context(), name, v.Path, checked, types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: !httputils.BoolValue(r, "noOverwriteDirNonDir"),
 		CopyUIDGID: httputils.BoolValue(r, "copyUIDGID"),
 	})
+	if violation := inspector.wait(checked); violation != nil {
+		// daemon only reports a truncated archive
+		writeError(w, r, violation)
+		return
+	}
 
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 }
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+func TestBindMountsAreDenied(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	for _, config := range []map[string]interface{}{
+		{"Image": "busybox", "HostConfig": map[string]interface{}{"Binds": []string{"/etc:/host/etc"}}},
+		{"Image": "busybox", "HostConfig": map[string]interface{}{"Mounts": []map[string]interface{}{
+			{"Type": "bind", "Source": "/var/run/docker.sock", "Target": "/var/run/docker.sock"},
+		}}},
+	} {
+		res := tp.do("POST", "/containers/create", config)
+		if res.Header.Get(DeniedHeader) == "" {
+			t.Errorf("denial should be flagged with %s header", DeniedHeader)
+		}
+		tp.expectError(res, http.StatusForbidden, "Lancelot policy denied request")
+	}
+
+	list, _ := tp.daemon.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if len(list) != 0 {
+		t.Fatalf("no container should have been created, got %v", list)
+	}
+}
+
+func TestNamedVolumeBindIsOwned(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	id := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"data:/data"}},
+	}, "")
+
+	// volume is in use
+	tp.expect(tp.do("DELETE", "/volumes/data", nil), http.StatusInternalServerError)
+	tp.expect(tp.do("DELETE", "/containers/"+id, nil), http.StatusNoContent)
+	tp.expect(tp.do("DELETE", "/volumes/data", nil), http.StatusNoContent)
+}
+
+func TestContainersArePlacedInCgroup(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	id := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"CgroupParent": "/elsewhere"},
+	}, "")
+	json, err := tp.daemon.ContainerInspect(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.HostConfig.CgroupParent != testCgroup {
+		t.Fatalf("expected cgroup parent %s, got %s", testCgroup, json.HostConfig.CgroupParent)
+	}
+}
+
+func TestMisplacedContainerIsRemoved(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.daemon.IgnoreCgroupParent = true
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "busybox"}), http.StatusInternalServerError, "not under "+testCgroup)
+
+	list, _ := tp.daemon.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if len(list) != 0 {
+		t.Fatalf("misplaced container should have been removed, got %v", list)
+	}
+}
+
+func TestPlacementIsUnverifiedWithoutProc(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	audit := &auditBuffer{}
+	tp.proxy.SetAuditTrail(NewAuditTrail(audit))
+
+	// fake containers have no process, cgroup can't be read from /proc
+	id := tp.run("busybox")
+	placements := 0
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		e := AuditEvent{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Action != "placement" {
+			continue
+		}
+		placements++
+		if e.Resource != id || e.Details["verified"] != false || e.Details["source"] != "daemon" || e.Details["reported"] != testCgroup || e.Details["effective"] != nil {
+			t.Fatalf("expected placement to be recorded as unverified, got %+v", e)
+		}
+	}
+	if placements != 2 {
+		t.Fatalf("expected placement to be recorded on create and start, got %d", placements)
+	}
+}
+
+func TestUpstreamErrorsArePropagated(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	id := tp.create(map[string]interface{}{"Image": "busybox"}, "?name=web")
+	tp.expectError(tp.do("POST", "/containers/create?name=web", map[string]interface{}{"Image": "busybox"}), http.StatusConflict, "already in use")
+	tp.expectError(tp.do("POST", "/containers/"+id+"/kill", nil), http.StatusConflict, "is not running")
+	tp.expectError(tp.do("GET", "/containers/"+id+"/logs", nil), http.StatusBadRequest, "at least one stream")
+	tp.expect(tp.do("POST", "/containers/web/start", nil), http.StatusNoContent)
+	tp.expect(tp.do("POST", "/containers/web/stop", nil), http.StatusNoContent)
+	tp.expect(tp.do("DELETE", "/containers/web", nil), http.StatusNoContent)
+}
+
+/**
+ Send a request which hijacks connection, and return the raw stream once upgraded
+ */
+func (tp *testProxy) hijack(path, body string) (net.Conn, *bufio.Reader) {
+	tp.t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(tp.server.URL, "http://"))
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "POST /v1.31%s HTTP/1.1\r\nHost: docker\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n%s", path, len(body), body)
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadString('\n')
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	if !strings.HasPrefix(status, "HTTP/1.1 101") {
+		tp.t.Fatalf("expected connection to be upgraded, got %s", status)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			tp.t.Fatal(err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	return conn, r
+}
+
+func expectEcho(t *testing.T, conn net.Conn, r *bufio.Reader) {
+	t.Helper()
+	if _, err := io.WriteString(conn, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Fatalf("expected stdin to be echoed, got %q", line)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	id := tp.run("busybox")
+	conn, r := tp.hijack("/containers/"+id+"/attach?stream=1&stdin=1&stdout=1", "")
+	defer conn.Close()
+	expectEcho(t, conn, r)
+}
+
+func TestExec(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	id := tp.run("busybox")
+	exec := types.IDResponse{}
+	tp.decode(tp.do("POST", "/containers/"+id+"/exec", map[string]interface{}{"Cmd": []string{"cat"}, "AttachStdin": true, "AttachStdout": true}), http.StatusCreated, &exec)
+	tp.expectError(tp.do("POST", "/containers/"+id+"/exec", map[string]interface{}{}), http.StatusBadRequest, "No exec command specified")
+	tp.expectError(tp.do("POST", "/exec/"+exec.ID+"/start", map[string]interface{}{"Detach": true}), http.StatusBadRequest, "Detached exec")
+
+	conn, r := tp.hijack("/exec/"+exec.ID+"/start?stdin=1&stdout=1", `{"Detach":false,"Tty":true}`)
+	defer conn.Close()
+	expectEcho(t, conn, r)
+
+	inspect := types.ContainerExecInspect{}
+	tp.decode(tp.do("GET", "/exec/"+exec.ID+"/json", nil), http.StatusOK, &inspect)
+	if inspect.ContainerID != id {
+		t.Fatalf("expected exec in container %s, got %s", id, inspect.ContainerID)
+	}
+}
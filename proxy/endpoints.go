@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	volumetypes "github.com/docker/docker/api/types/volume"
+)
+
+// endpoints forwarded by the generic layer, see Endpoint
+var endpoints = []Endpoint{
+	{
+		Method: "POST",
+		Path:   "/volumes/create",
+		Body:   func() interface{} { return &volumetypes.VolumesCreateBody{} },
+		Rules: []FieldRule{
+			{Field: "Name", Action: Allow},
+			{Field: "Driver", Action: Allow},
+			// checked by checkVolumeDriver, local driver could bind mount host paths
+			{Field: "DriverOpts", Action: Allow},
+			{Field: "Labels", Action: Allow},
+		},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			req := body.(*volumetypes.VolumesCreateBody)
+			if err := checkVolumeDriver(req.Driver, req.DriverOpts, p.policy.Storage.tmpfsSize()); err != nil {
+				return nil, err
+			}
+			if err := p.checkQuota(r.Context(), VolumesQuota, VolumesSizeQuota); err != nil {
+				return nil, err
+			}
+			req.Labels = p.injectLabels(retentionLabels(p.policy.Teardown.Volumes, req.Labels))
+			req.Name = p.daemonName(req.Name)
+			volume, err := p.client.VolumeCreate(r.Context(), *req)
+			if err != nil {
+				return nil, p.clientError(err)
+			}
+			p.addVolume(volume.Name)
+			volume.Name = p.clientName(volume.Name)
+			return volume, nil
+		},
+		Status: http.StatusCreated,
+	},
+	{
+		Method: "POST",
+		Path:   "/containers/{name:.*}/pause",
+		Owns:   map[string]string{"name": ContainerResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			return nil, p.client.ContainerPause(r.Context(), vars["name"])
+		},
+		Status: http.StatusNoContent,
+	},
+	{
+		Method: "POST",
+		Path:   "/containers/{name:.*}/unpause",
+		Owns:   map[string]string{"name": ContainerResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			return nil, p.client.ContainerUnpause(r.Context(), vars["name"])
+		},
+		Status: http.StatusNoContent,
+	},
+	{
+		Method: "POST",
+		Path:   "/containers/{name:.*}/restart",
+		Owns:   map[string]string{"name": ContainerResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			var timeout *time.Duration
+			if t := r.Form.Get("t"); t != "" {
+				seconds, err := strconv.Atoi(t)
+				if err != nil {
+					return nil, err
+				}
+				d := time.Duration(seconds) * time.Second
+				timeout = &d
+			}
+			return nil, p.client.ContainerRestart(r.Context(), vars["name"], timeout)
+		},
+		Status: http.StatusNoContent,
+	},
+	{
+		Method: "POST",
+		Path:   "/containers/{name:.*}/wait",
+		Owns:   map[string]string{"name": ContainerResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			condition := container.WaitCondition(r.Form.Get("condition"))
+			if condition == "" {
+				condition = container.WaitConditionNotRunning
+			}
+			wait, errs := p.client.ContainerWait(r.Context(), vars["name"], condition)
+			select {
+			case res := <-wait:
+				return res, nil
+			case err := <-errs:
+				return nil, err
+			}
+		},
+	},
+	{
+		Method: "GET",
+		Path:   "/containers/{name:.*}/top",
+		Owns:   map[string]string{"name": ContainerResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			args := []string{}
+			if ps := r.Form.Get("ps_args"); ps != "" {
+				args = append(args, ps)
+			}
+			return p.client.ContainerTop(r.Context(), vars["name"], args)
+		},
+	},
+	{
+		Method: "POST",
+		Path:   "/containers/{name:.*}/update",
+		Owns:   map[string]string{"name": ContainerResource},
+		Body:   func() interface{} { return &container.UpdateConfig{} },
+		// resources can be tuned, but container still runs under our cgroup limits
+		Rules: []FieldRule{
+			{Field: "Resources.Memory", Action: Allow},
+			{Field: "Resources.MemoryReservation", Action: Allow},
+			{Field: "Resources.MemorySwap", Action: Allow},
+			{Field: "Resources.CPUShares", Action: Allow},
+			{Field: "Resources.CPUPeriod", Action: Allow},
+			{Field: "Resources.CPUQuota", Action: Allow},
+			{Field: "Resources.NanoCPUs", Action: Allow},
+			{Field: "Resources.PidsLimit", Action: Allow},
+			{Field: "Resources.CgroupParent", Action: Deny},
+			{Field: "Resources.Devices", Action: Deny},
+		},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			return p.client.ContainerUpdate(r.Context(), vars["name"], *body.(*container.UpdateConfig))
+		},
+	},
+	{
+		Method: "GET",
+		Path:   "/volumes/{name:.*}",
+		Owns:   map[string]string{"name": VolumeResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			volume, err := p.client.VolumeInspect(r.Context(), vars["name"])
+			if err != nil {
+				return nil, err
+			}
+			volume.Name = p.clientName(volume.Name)
+			return volume, nil
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/networks",
+		Profile: ComposeProfile,
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			filter, err := filters.FromParam(r.Form.Get("filters"))
+			if err != nil {
+				return nil, err
+			}
+			networks, err := p.client.NetworkList(r.Context(), types.NetworkListOptions{Filters: p.nameFilter(filter)})
+			if err != nil {
+				return nil, err
+			}
+			mine := []types.NetworkResource{}
+			for _, n := range networks {
+				if _, err := p.ownsNetwork(n.ID); err == nil {
+					mine = append(mine, p.clientNetwork(n))
+				}
+			}
+			return mine, nil
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/networks/create",
+		Profile: ComposeProfile,
+		Body:    func() interface{} { return &types.NetworkCreateRequest{} },
+		// only plain bridge networks, IPAM and driver options could interfere with host network
+		Rules: []FieldRule{
+			{Field: "Name", Action: Allow},
+			{Field: "CheckDuplicate", Action: Allow},
+			{Field: "Driver", Action: Allow},
+			{Field: "Internal", Action: Allow},
+			{Field: "Attachable", Action: Allow},
+			{Field: "Labels", Action: Allow},
+		},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			req := body.(*types.NetworkCreateRequest)
+			if req.Driver != "" && req.Driver != "bridge" {
+				return nil, denied("network driver %s is not authorized", req.Driver)
+			}
+			req.Labels = p.injectLabels(req.Labels)
+			name := p.daemonName(req.Name)
+			res, err := p.client.NetworkCreate(r.Context(), name, req.NetworkCreate)
+			if err != nil {
+				return nil, p.clientError(err)
+			}
+			p.addNetwork(res.ID)
+			p.addNetwork(name)
+			return res, nil
+		},
+		Status: http.StatusCreated,
+	},
+	{
+		Method:  "GET",
+		Path:    "/networks/{id:.*}",
+		Profile: ComposeProfile,
+		Owns:    map[string]string{"id": NetworkResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			n, err := p.client.NetworkInspect(r.Context(), vars["id"], types.NetworkInspectOptions{
+				Scope:   r.Form.Get("scope"),
+				Verbose: httputils.BoolValue(r, "verbose"),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return p.clientNetwork(n), nil
+		},
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/networks/{id:.*}",
+		Profile: ComposeProfile,
+		Owns:    map[string]string{"id": NetworkResource},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			return nil, p.clientError(p.client.NetworkRemove(r.Context(), vars["id"]))
+		},
+		Status: http.StatusNoContent,
+	},
+	{
+		Method:  "POST",
+		Path:    "/networks/{id:.*}/connect",
+		Profile: ComposeProfile,
+		Owns:    map[string]string{"id": NetworkResource},
+		Body:    func() interface{} { return &types.NetworkConnect{} },
+		Rules: []FieldRule{
+			{Field: "Container", Action: Allow},
+			{Field: "EndpointConfig.Aliases", Action: Allow},
+		},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			req := body.(*types.NetworkConnect)
+			id, err := p.ownsContainer(req.Container)
+			if err != nil {
+				return nil, err
+			}
+			return nil, p.clientError(p.client.NetworkConnect(r.Context(), vars["id"], id, req.EndpointConfig))
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/networks/{id:.*}/disconnect",
+		Profile: ComposeProfile,
+		Owns:    map[string]string{"id": NetworkResource},
+		Body:    func() interface{} { return &types.NetworkDisconnect{} },
+		Rules: []FieldRule{
+			{Field: "Container", Action: Allow},
+			{Field: "Force", Action: Allow},
+		},
+		Forward: func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error) {
+			req := body.(*types.NetworkDisconnect)
+			id, err := p.ownsContainer(req.Container)
+			if err != nil {
+				return nil, err
+			}
+			return nil, p.clientError(p.client.NetworkDisconnect(r.Context(), vars["id"], id, req.Force))
+		},
+	},
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+)
+
+// DeniedHeader is set on responses to requests denied by Lancelot policy, so they can't be confused with daemon errors
+const DeniedHeader = "X-Lancelot-Denied"
+
+// statusError is an error with the HTTP status to report it with, as understood by httputils.GetHTTPErrorStatusCode
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e statusError) Error() string {
+	return e.message
+}
+
+func (e statusError) HTTPErrorStatusCode() int {
+	return e.status
+}
+
+/**
+ Request denied by Lancelot policy
+ */
+func denied(format string, args ...interface{}) error {
+	return statusError{http.StatusForbidden, "Lancelot policy denied request: " + fmt.Sprintf(format, args...)}
+}
+
+/**
+ Resource doesn't exist, or isn't owned by tenant, which we don't make a difference for
+ */
+func notFound(format string, args ...interface{}) error {
+	return statusError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return statusError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func isDenied(err error) bool {
+	e, ok := err.(statusError)
+	return ok && e.status == http.StatusForbidden
+}
+
+/**
+ HTTP status for an error, our own or returned by daemon. Upstream client doesn't keep daemon status, so we rely on
+ error message, as docker engine does.
+ */
+func errorStatus(err error) int {
+	status := httputils.GetHTTPErrorStatusCode(err)
+	if status == http.StatusInternalServerError {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "forbidden"), strings.Contains(msg, "denied"):
+			status = http.StatusForbidden
+		case strings.Contains(msg, "already in use"), strings.Contains(msg, "is already"),
+			strings.Contains(msg, "is not running"), strings.Contains(msg, "is paused"):
+			status = http.StatusConflict
+		}
+	}
+	return status
+}
+
+/**
+ Write a docker compatible error response: JSON `{"message": ...}` body, or plain text for clients using API < 1.24
+ */
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Println(err.Error())
+	status := errorStatus(err)
+	if isDenied(err) {
+		w.Header().Set(DeniedHeader, "true")
+	}
+	if versions.LessThan(apiVersion(r), "1.24") {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	httputils.WriteJSON(w, status, &types.ErrorResponse{
+		Message: err.Error(),
+	})
+}
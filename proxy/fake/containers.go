@@ -0,0 +1,671 @@
+package fake
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stringid"
+	"golang.org/x/net/context"
+)
+
+/**
+ Lookup a container by ID, name or unique ID prefix. Must be called with lock held.
+ */
+func (d *Daemon) container(ref string) (*types.ContainerJSON, error) {
+	if c, ok := d.containers[ref]; ok {
+		return c, nil
+	}
+	var found *types.ContainerJSON
+	for id, c := range d.containers {
+		if c.Name == "/"+strings.TrimPrefix(ref, "/") {
+			return c, nil
+		}
+		if strings.HasPrefix(id, ref) {
+			if found != nil {
+				return nil, daemonError("multiple IDs found with provided prefix: %s", ref)
+			}
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, notFound("No such container: %s", ref)
+	}
+	return found, nil
+}
+
+func (d *Daemon) running(ref string) (*types.ContainerJSON, error) {
+	c, err := d.container(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !c.State.Running {
+		return nil, daemonError("Container %s is not running", c.ID)
+	}
+	return c, nil
+}
+
+func (d *Daemon) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	body := container.ContainerCreateCreatedBody{Warnings: []string{}}
+	image, err := d.image(config.Image)
+	if err != nil {
+		return body, notFound("No such image: %s", config.Image)
+	}
+
+	id := stringid.GenerateRandomID()
+	name := containerName
+	if name == "" {
+		name = stringid.TruncateID(id)
+	}
+	for _, c := range d.containers {
+		if c.Name == "/"+name {
+			return body, daemonError("Conflict. The container name \"/%s\" is already in use by container \"%s\". You have to remove (or rename) that container to be able to reuse that name.", name, c.ID)
+		}
+	}
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+	hostConfig = copyHostConfig(hostConfig)
+	if d.IgnoreCgroupParent {
+		hostConfig.CgroupParent = ""
+	}
+
+	mounts := []types.MountPoint{}
+	for dest := range config.Volumes {
+		v := d.createVolume("", nil, nil)
+		mounts = append(mounts, types.MountPoint{Type: mount.TypeVolume, Name: v.Name, Source: v.Mountpoint, Destination: dest, Driver: v.Driver, RW: true})
+	}
+	for _, b := range hostConfig.Binds {
+		parts := strings.Split(b, ":")
+		if len(parts) < 2 {
+			return body, daemonError("invalid volume specification: '%s'", b)
+		}
+		m := types.MountPoint{Source: parts[0], Destination: parts[1], RW: true}
+		if len(parts) > 2 {
+			m.Mode = parts[2]
+			m.RW = !strings.Contains(parts[2], "ro")
+		}
+		if path.IsAbs(parts[0]) {
+			m.Type = mount.TypeBind
+		} else {
+			v := d.volumeOrCreate(parts[0], nil)
+			m.Type, m.Name, m.Source, m.Driver = mount.TypeVolume, v.Name, v.Mountpoint, v.Driver
+		}
+		mounts = append(mounts, m)
+	}
+	for _, m := range hostConfig.Mounts {
+		p := types.MountPoint{Type: m.Type, Source: m.Source, Destination: m.Target, RW: !m.ReadOnly}
+		if m.Type == mount.TypeVolume {
+			var options map[string]string
+			if m.VolumeOptions != nil && m.VolumeOptions.DriverConfig != nil {
+				options = m.VolumeOptions.DriverConfig.Options
+			}
+			v := d.volumeOrCreate(m.Source, options)
+			p.Name, p.Source, p.Driver = v.Name, v.Mountpoint, v.Driver
+		}
+		mounts = append(mounts, p)
+	}
+
+	c := &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Created:    time.Now().UTC().Format(time.RFC3339Nano),
+			Name:       "/" + name,
+			Image:      image.ID,
+			State:      &types.ContainerState{Status: "created"},
+			HostConfig: hostConfig,
+			Driver:     "overlay2",
+		},
+		Mounts:          mounts,
+		Config:          config,
+		NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{}},
+	}
+	mode := string(hostConfig.NetworkMode)
+	if mode == "" || mode == "default" {
+		mode = "bridge"
+	}
+	if !strings.HasPrefix(mode, "container:") && mode != "host" && mode != "none" {
+		var endpoint *network.EndpointSettings
+		if networkingConfig != nil {
+			endpoint = networkingConfig.EndpointsConfig[mode]
+		}
+		if err := d.connect(c, mode, endpoint); err != nil {
+			return body, err
+		}
+	}
+	if len(config.Cmd) > 0 {
+		c.Path, c.Args = config.Cmd[0], config.Cmd[1:]
+	}
+	d.containers[id] = c
+	d.emit(events.ContainerEventType, "create", id, d.attributes(c))
+	body.ID = id
+	return body, nil
+}
+
+func copyHostConfig(h *container.HostConfig) *container.HostConfig {
+	c := *h
+	return &c
+}
+
+// event attributes for a container, as set by daemon
+func (d *Daemon) attributes(c *types.ContainerJSON) map[string]string {
+	attributes := map[string]string{
+		"image": c.Config.Image,
+		"name":  strings.TrimPrefix(c.Name, "/"),
+	}
+	for k, v := range c.Config.Labels {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+func (d *Daemon) ContainerInspect(ctx context.Context, ref string) (types.ContainerJSON, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	return *c, nil
+}
+
+func (d *Daemon) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	list := []types.Container{}
+	for _, c := range d.containers {
+		if !options.All && !c.State.Running {
+			continue
+		}
+		if !options.Filters.MatchKVList("label", c.Config.Labels) ||
+			!options.Filters.ExactMatch("status", c.State.Status) ||
+			!options.Filters.Match("name", c.Name) {
+			continue
+		}
+		list = append(list, types.Container{
+			ID:      c.ID,
+			Names:   []string{c.Name},
+			Image:   c.Config.Image,
+			ImageID: c.Image,
+			Command: strings.Join(c.Config.Cmd, " "),
+			Labels:  c.Config.Labels,
+			State:   c.State.Status,
+			Status:  c.State.Status,
+			Mounts:  c.Mounts,
+		})
+	}
+	return list, nil
+}
+
+func (d *Daemon) ContainerStart(ctx context.Context, ref string, options types.ContainerStartOptions) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return err
+	}
+	if c.State.Running {
+		return nil
+	}
+	c.State = &types.ContainerState{
+		Status:    "running",
+		Running:   true,
+		StartedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if h := c.Config.Healthcheck; h != nil && len(h.Test) > 0 && h.Test[0] != "NONE" {
+		// echo process is always healthy
+		c.State.Health = &types.Health{Status: types.Healthy}
+	}
+	d.emit(events.ContainerEventType, "start", c.ID, d.attributes(c))
+	return nil
+}
+
+/**
+ Terminate container process. Must be called with lock held.
+ */
+func (d *Daemon) exit(c *types.ContainerJSON, code int) {
+	c.State = &types.ContainerState{
+		Status:     "exited",
+		ExitCode:   code,
+		StartedAt:  c.State.StartedAt,
+		FinishedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	attributes := d.attributes(c)
+	attributes["exitCode"] = strconv.Itoa(code)
+	d.emit(events.ContainerEventType, "die", c.ID, attributes)
+}
+
+func (d *Daemon) ContainerStop(ctx context.Context, ref string, timeout *time.Duration) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return err
+	}
+	if c.State.Running {
+		d.exit(c, 0)
+	}
+	return nil
+}
+
+func (d *Daemon) ContainerKill(ctx context.Context, ref, signal string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.running(ref)
+	if err != nil {
+		return err
+	}
+	d.exit(c, 137)
+	return nil
+}
+
+func (d *Daemon) ContainerRestart(ctx context.Context, ref string, timeout *time.Duration) error {
+	if err := d.ContainerStop(ctx, ref, timeout); err != nil {
+		return err
+	}
+	return d.ContainerStart(ctx, ref, types.ContainerStartOptions{})
+}
+
+func (d *Daemon) ContainerPause(ctx context.Context, ref string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.running(ref)
+	if err != nil {
+		return err
+	}
+	c.State.Paused = true
+	c.State.Status = "paused"
+	d.emit(events.ContainerEventType, "pause", c.ID, d.attributes(c))
+	return nil
+}
+
+func (d *Daemon) ContainerUnpause(ctx context.Context, ref string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return err
+	}
+	if !c.State.Paused {
+		return daemonError("Container %s is not paused", c.ID)
+	}
+	c.State.Paused = false
+	c.State.Status = "running"
+	d.emit(events.ContainerEventType, "unpause", c.ID, d.attributes(c))
+	return nil
+}
+
+func (d *Daemon) ContainerRemove(ctx context.Context, ref string, options types.ContainerRemoveOptions) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return err
+	}
+	if c.State.Running {
+		if !options.Force {
+			return daemonError("You cannot remove a running container %s. Stop the container before attempting removal or force remove", c.ID)
+		}
+		d.exit(c, 137)
+	}
+	if options.RemoveVolumes {
+		for _, m := range c.Mounts {
+			if m.Type == mount.TypeVolume {
+				delete(d.volumes, m.Name)
+			}
+		}
+	}
+	delete(d.containers, c.ID)
+	delete(d.files, c.ID)
+	delete(d.symlinks, c.ID)
+	d.emit(events.ContainerEventType, "destroy", c.ID, d.attributes(c))
+	return nil
+}
+
+func (d *Daemon) ContainerResize(ctx context.Context, ref string, options types.ResizeOptions) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	_, err := d.running(ref)
+	return err
+}
+
+func (d *Daemon) ContainerWait(ctx context.Context, ref string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	results := make(chan container.ContainerWaitOKBody, 1)
+	errs := make(chan error, 1)
+
+	d.mux.Lock()
+	c, err := d.container(ref)
+	d.mux.Unlock()
+	if err != nil {
+		errs <- err
+		return results, errs
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	msgs, _ := d.Events(ctx, types.EventsOptions{})
+	go func() {
+		defer cancel()
+		d.mux.Lock()
+		state := *c.State
+		d.mux.Unlock()
+		if !state.Running && condition != container.WaitConditionRemoved {
+			results <- container.ContainerWaitOKBody{StatusCode: int64(state.ExitCode)}
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			case m := <-msgs:
+				if m.Actor.ID != c.ID {
+					continue
+				}
+				if (m.Action == "die" && condition != container.WaitConditionRemoved) || m.Action == "destroy" {
+					code, _ := strconv.Atoi(m.Actor.Attributes["exitCode"])
+					results <- container.ContainerWaitOKBody{StatusCode: int64(code)}
+					return
+				}
+			}
+		}
+	}()
+	return results, errs
+}
+
+func (d *Daemon) ContainerTop(ctx context.Context, ref string, arguments []string) (container.ContainerTopOKBody, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.running(ref)
+	if err != nil {
+		return container.ContainerTopOKBody{}, err
+	}
+	return container.ContainerTopOKBody{
+		Titles:    []string{"PID", "CMD"},
+		Processes: [][]string{{"1", strings.Join(c.Config.Cmd, " ")}},
+	}, nil
+}
+
+func (d *Daemon) ContainerUpdate(ctx context.Context, ref string, updateConfig container.UpdateConfig) (container.ContainerUpdateOKBody, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return container.ContainerUpdateOKBody{}, err
+	}
+	c.HostConfig.Resources = updateConfig.Resources
+	return container.ContainerUpdateOKBody{Warnings: []string{}}, nil
+}
+
+func (d *Daemon) ContainerLogs(ctx context.Context, ref string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, err := d.container(ref); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+/**
+ Attach to container process, which echoes stdin to stdout
+ */
+func (d *Daemon) ContainerAttach(ctx context.Context, ref string, options types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, err := d.container(ref); err != nil {
+		return types.HijackedResponse{}, err
+	}
+	return echo(), nil
+}
+
+/**
+ Stream to a process echoing its stdin, over a socket pair so it can be half-closed like a daemon connection
+ */
+func echo() types.HijackedResponse {
+	client, process := socketPair()
+	go func() {
+		defer process.Close()
+		io.Copy(process, process)
+	}()
+	return types.HijackedResponse{Conn: client, Reader: bufio.NewReader(client)}
+}
+
+func socketPair() (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		panic(err)
+	}
+	conns := []net.Conn{}
+	for _, fd := range fds {
+		f := os.NewFile(uintptr(fd), "echo")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+		conns = append(conns, c)
+	}
+	return conns[0], conns[1]
+}
+
+func (d *Daemon) ContainerExecCreate(ctx context.Context, ref string, config types.ExecConfig) (types.IDResponse, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.running(ref)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+	id := stringid.GenerateRandomID()
+	d.execs[id] = &types.ContainerExecInspect{ExecID: id, ContainerID: c.ID}
+	c.ExecIDs = append(c.ExecIDs, id)
+	return types.IDResponse{ID: id}, nil
+}
+
+func (d *Daemon) exec(id string) (*types.ContainerExecInspect, error) {
+	e, ok := d.execs[id]
+	if !ok {
+		return nil, daemonError("No such exec instance: %s", id)
+	}
+	return e, nil
+}
+
+func (d *Daemon) ContainerExecInspect(ctx context.Context, id string) (types.ContainerExecInspect, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	e, err := d.exec(id)
+	if err != nil {
+		return types.ContainerExecInspect{}, err
+	}
+	return *e, nil
+}
+
+func (d *Daemon) ContainerExecAttach(ctx context.Context, id string, config types.ExecConfig) (types.HijackedResponse, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	e, err := d.exec(id)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+	e.Running = true
+	return echo(), nil
+}
+
+func (d *Daemon) ContainerExecResize(ctx context.Context, id string, options types.ResizeOptions) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	_, err := d.exec(id)
+	return err
+}
+
+/**
+ Extract a tar archive in container. Files are only kept in memory, so they can be copied back.
+ */
+func (d *Daemon) CopyToContainer(ctx context.Context, ref, dest string, content io.Reader, options types.CopyToContainerOptions) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return err
+	}
+	files := d.files[c.ID]
+	if files == nil {
+		files = map[string][]byte{}
+		d.files[c.ID] = files
+	}
+	tr := tar.NewReader(content)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return daemonError("%s", err.Error())
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return daemonError("%s", err.Error())
+		}
+		if h.Typeflag == tar.TypeReg || h.Typeflag == tar.TypeRegA {
+			target := path.Join(dest, h.Name)
+			for f := range files {
+				if strings.HasPrefix(f, target+"/") && !options.AllowOverwriteDirWithFile {
+					return daemonError("cannot overwrite directory %q with non-directory %q", target, h.Name)
+				}
+			}
+			files[target] = b
+		}
+		if h.Typeflag == tar.TypeSymlink {
+			if d.symlinks[c.ID] == nil {
+				d.symlinks[c.ID] = map[string]string{}
+			}
+			d.symlinks[c.ID][path.Join(dest, h.Name)] = h.Linkname
+		}
+	}
+}
+
+/**
+ Stat a file or symlink previously copied to container. Their parent directories and container mount points are
+ reported as directories.
+ */
+func (d *Daemon) ContainerStatPath(ctx context.Context, ref, p string) (types.ContainerPathStat, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(ref)
+	if err != nil {
+		return types.ContainerPathStat{}, err
+	}
+	p = d.resolveParents(c.ID, p)
+	stat := types.ContainerPathStat{Name: path.Base(p), Mtime: time.Now()}
+	if target, ok := d.symlinks[c.ID][p]; ok {
+		stat.Mode, stat.LinkTarget = os.ModeSymlink|0777, target
+		return stat, nil
+	}
+	if b, ok := d.files[c.ID][p]; ok {
+		stat.Mode, stat.Size = 0644, int64(len(b))
+		return stat, nil
+	}
+	stat.Mode = os.ModeDir | 0755
+	for _, m := range c.Mounts {
+		if m.Destination == p {
+			return stat, nil
+		}
+	}
+	for f := range d.files[c.ID] {
+		if strings.HasPrefix(f, p+"/") {
+			return stat, nil
+		}
+	}
+	for l := range d.symlinks[c.ID] {
+		if strings.HasPrefix(l, p+"/") {
+			return stat, nil
+		}
+	}
+	// HEAD response has no body, so client only reports status
+	return types.ContainerPathStat{}, notFound("request returned Not Found for API route and version /containers/%s/archive, check if the server supports the requested API version", ref)
+}
+
+/**
+ Follow symlinks in parent directories of a path, as daemon does, the last component being left as is
+ */
+func (d *Daemon) resolveParents(id, p string) string {
+	p = path.Clean("/" + p)
+	// bounded, as daemon gives up on symlink loops
+	for i := 0; i < 32; i++ {
+		parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		dir, resolved := "/", false
+		for j, c := range parts[:len(parts)-1] {
+			dir = path.Join(dir, c)
+			if target, ok := d.symlinks[id][dir]; ok {
+				if !path.IsAbs(target) {
+					target = path.Join(path.Dir(dir), target)
+				}
+				p, resolved = path.Join(append([]string{target}, parts[j+1:]...)...), true
+				break
+			}
+		}
+		if !resolved {
+			break
+		}
+	}
+	return p
+}
+
+/**
+ Get a file or directory previously copied to container, as a tar archive
+ */
+func (d *Daemon) CopyFromContainer(ctx context.Context, ref, src string) (io.ReadCloser, types.ContainerPathStat, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	stat := types.ContainerPathStat{}
+	c, err := d.container(ref)
+	if err != nil {
+		return nil, stat, err
+	}
+	src = d.resolveParents(c.ID, src)
+	stat = types.ContainerPathStat{Name: path.Base(src), Mode: 0644, Mtime: time.Now()}
+
+	// entries are named relative to parent of source, as daemon does
+	names := []string{}
+	if b, ok := d.files[c.ID][src]; ok {
+		names, stat.Size = append(names, src), int64(len(b))
+	} else {
+		for f := range d.files[c.ID] {
+			if strings.HasPrefix(f, src+"/") {
+				names = append(names, f)
+			}
+		}
+		if len(names) == 0 {
+			return nil, stat, notFound("Could not find the file %s in container %s", src, ref)
+		}
+		stat.Mode = os.ModeDir | 0755
+		sort.Strings(names)
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range names {
+		b := d.files[c.ID][f]
+		name := strings.TrimPrefix(f, path.Dir(src)+"/")
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: stat.Mtime, Typeflag: tar.TypeReg})
+		tw.Write(b)
+	}
+	tw.Close()
+	return ioutil.NopCloser(buf), stat, nil
+}
@@ -0,0 +1,293 @@
+package fake
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// APIVersion served by fake daemon
+const APIVersion = "1.31"
+
+// Daemon is an in-memory docker daemon, implementing client.APIClient for the API subset Lancelot relies on, so proxy
+// can be tested without a real dockerd. Containers, images, volumes, networks and execs are kept in maps. Containers don't run
+// anything: an attached or exec'd process just echoes its stdin to stdout.
+//
+// Methods which are not implemented panic, as calling them from proxy is a bug on its own.
+type Daemon struct {
+	client.APIClient
+
+	// CgroupDriver reported by Info
+	CgroupDriver string
+	// IgnoreCgroupParent simulates a daemon which doesn't honor HostConfig.CgroupParent
+	IgnoreCgroupParent bool
+
+	containers map[string]*types.ContainerJSON
+	files      map[string]map[string][]byte // files copied to containers, by container ID and path
+	symlinks   map[string]map[string]string // symlinks copied to containers, target by container ID and path
+	execs      map[string]*types.ContainerExecInspect
+	images     map[string]*types.ImageInspect // by image ID
+	tags       map[string]string              // image ID by familiar reference, like `ubuntu:latest`
+	registry   map[string]*remoteImage        // images available from registries, by familiar reference
+	volumes    map[string]*types.Volume
+	volumeSizes map[string]int64 // disk space used by volumes, see SetVolumeSize
+	networks   map[string]*types.NetworkResource // by network ID
+	history    []events.Message // all events emitted, replayed to subscribers asking for past ones
+	subscribers []*subscription
+	mux        sync.Mutex
+}
+
+// subscription to daemon events, which can be interrupted like a dropped connection to daemon
+type subscription struct {
+	messages    chan events.Message
+	interrupted chan struct{}
+}
+
+func NewDaemon() *Daemon {
+	d := &Daemon{
+		CgroupDriver: "cgroupfs",
+		containers:   map[string]*types.ContainerJSON{},
+		files:        map[string]map[string][]byte{},
+		symlinks:     map[string]map[string]string{},
+		execs:        map[string]*types.ContainerExecInspect{},
+		images:       map[string]*types.ImageInspect{},
+		tags:         map[string]string{},
+		registry:     map[string]*remoteImage{},
+		volumes:      map[string]*types.Volume{},
+		volumeSizes:  map[string]int64{},
+		networks:     map[string]*types.NetworkResource{},
+	}
+	d.createNetwork("bridge", "bridge", nil)
+	return d
+}
+
+// notFoundError is the error client returns for missing resources, see client.IsErrNotFound
+type notFoundError struct {
+	message string
+}
+
+func (e notFoundError) Error() string {
+	return "Error: " + e.message
+}
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+func notFound(format string, args ...interface{}) error {
+	return notFoundError{fmt.Sprintf(format, args...)}
+}
+
+// daemonError is an error response from daemon, as reported by client
+func daemonError(format string, args ...interface{}) error {
+	return fmt.Errorf("Error response from daemon: "+format, args...)
+}
+
+func (d *Daemon) ClientVersion() string {
+	return APIVersion
+}
+
+func (d *Daemon) NegotiateAPIVersion(ctx context.Context) {
+}
+
+func (d *Daemon) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: APIVersion, OSType: "linux"}, nil
+}
+
+func (d *Daemon) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{
+		Version:       "17.06.0-fake",
+		APIVersion:    APIVersion,
+		MinAPIVersion: "1.12",
+		Os:            "linux",
+		Arch:          "amd64",
+	}, nil
+}
+
+func (d *Daemon) Info(ctx context.Context) (types.Info, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	info := types.Info{
+		ID:                 "FAKE:DAEMON",
+		Name:               "fake-host",
+		ServerVersion:      "17.06.0-fake",
+		OperatingSystem:    "Fake Linux",
+		KernelVersion:      "4.9.0-fake",
+		Architecture:       "x86_64",
+		OSType:             "linux",
+		Driver:             "overlay2",
+		DockerRootDir:      "/var/lib/docker",
+		NCPU:               8,
+		MemTotal:           16 * 1024 * 1024 * 1024,
+		CgroupDriver:       d.CgroupDriver,
+		IndexServerAddress: "https://index.docker.io/v1/",
+		SecurityOptions:    []string{"name=seccomp,profile=default", "name=apparmor"},
+		Containers:         len(d.containers),
+		Images:             len(d.images),
+	}
+	for _, c := range d.containers {
+		switch {
+		case c.State.Paused:
+			info.ContainersPaused++
+		case c.State.Running:
+			info.ContainersRunning++
+		default:
+			info.ContainersStopped++
+		}
+	}
+	return info, nil
+}
+
+func (d *Daemon) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	du := types.DiskUsage{}
+	images, _ := d.ImageList(ctx, types.ImageListOptions{})
+	containers, _ := d.ContainerList(ctx, types.ContainerListOptions{All: true})
+	volumes, _ := d.VolumeList(ctx, filters.NewArgs())
+	for i := range images {
+		du.Images = append(du.Images, &images[i])
+		du.LayersSize += images[i].Size
+	}
+	for i := range containers {
+		du.Containers = append(du.Containers, &containers[i])
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, v := range volumes.Volumes {
+		usage := &types.VolumeUsageData{Size: d.volumeSizes[v.Name]}
+		for _, c := range containers {
+			for _, m := range c.Mounts {
+				if m.Name == v.Name {
+					usage.RefCount++
+				}
+			}
+		}
+		v.UsageData = usage
+		du.Volumes = append(du.Volumes, v)
+	}
+	return du, nil
+}
+
+/**
+ Subscribe to daemon events. Events since options.Since are replayed first, as daemon does. Stream is closed by
+ cancelling context, or by InterruptEvents.
+ */
+func (d *Daemon) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	errs := make(chan error, 1)
+	sub := &subscription{messages: make(chan events.Message, 100), interrupted: make(chan struct{})}
+
+	var since int64 = -1
+	if options.Since != "" {
+		s, n, err := timetypes.ParseTimestamps(options.Since, 0)
+		if err != nil {
+			errs <- err
+			return msgs, errs
+		}
+		since = s*int64(time.Second) + n
+	}
+
+	d.mux.Lock()
+	past := []events.Message{}
+	for _, m := range d.history {
+		if since >= 0 && m.TimeNano >= since {
+			past = append(past, m)
+		}
+	}
+	d.subscribers = append(d.subscribers, sub)
+	d.mux.Unlock()
+
+	go func() {
+		defer d.unsubscribe(sub)
+		for {
+			var m events.Message
+			if len(past) > 0 {
+				m, past = past[0], past[1:]
+			} else {
+				select {
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				case <-sub.interrupted:
+					errs <- io.ErrUnexpectedEOF
+					return
+				case m = <-sub.messages:
+				}
+			}
+			if !match(options.Filters, m) {
+				continue
+			}
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return msgs, errs
+}
+
+/**
+ Interrupt all event streams, as a daemon restart or a dropped connection does
+ */
+func (d *Daemon) InterruptEvents() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, s := range d.subscribers {
+		close(s.interrupted)
+	}
+	d.subscribers = nil
+}
+
+func (d *Daemon) unsubscribe(sub *subscription) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for i, s := range d.subscribers {
+		if s == sub {
+			d.subscribers = append(d.subscribers[:i], d.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func match(f filters.Args, m events.Message) bool {
+	return f.ExactMatch("type", m.Type) &&
+		f.ExactMatch("event", m.Action) &&
+		(f.ExactMatch("container", m.Actor.ID) || m.Type != events.ContainerEventType) &&
+		f.MatchKVList("label", m.Actor.Attributes)
+}
+
+/**
+ Send an event to subscribers. Must be called with lock held.
+ */
+func (d *Daemon) emit(kind, action, id string, attributes map[string]string) {
+	now := time.Now()
+	m := events.Message{
+		Type:     kind,
+		Action:   action,
+		Actor:    events.Actor{ID: id, Attributes: attributes},
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	if kind == events.ContainerEventType {
+		m.ID = id
+		m.Status = action
+	}
+	d.history = append(d.history, m)
+	for _, s := range d.subscribers {
+		select {
+		case s.messages <- m:
+		default:
+			// slow subscriber, as a real daemon we drop events rather than block
+		}
+	}
+}
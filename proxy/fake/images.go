@@ -0,0 +1,362 @@
+package fake
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+)
+
+// remoteImage is an image available from a registry
+type remoteImage struct {
+	id     string
+	labels map[string]string
+	// encoded X-Registry-Auth required to pull or push this image, none if empty
+	auth string
+}
+
+/**
+ Normalize an image reference to the familiar form used as tag, like `ubuntu:latest`
+ */
+func normalize(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named)), nil
+}
+
+/**
+ Lookup an image by reference, ID or unique ID prefix. Must be called with lock held.
+ */
+func (d *Daemon) image(ref string) (*types.ImageInspect, error) {
+	if tag, err := normalize(ref); err == nil {
+		if id, ok := d.tags[tag]; ok {
+			return d.images[id], nil
+		}
+	}
+	var found *types.ImageInspect
+	for id, i := range d.images {
+		if strings.HasPrefix(id, ref) || strings.HasPrefix(id, "sha256:"+ref) {
+			if found != nil {
+				return nil, notFound("No such image: %s", ref)
+			}
+			found = i
+		}
+	}
+	if found == nil {
+		return nil, notFound("No such image: %s", ref)
+	}
+	return found, nil
+}
+
+/**
+ Make an image available from registry. When auth is set, client has to send it as X-Registry-Auth to pull or push.
+ */
+func (d *Daemon) Publish(ref, auth string) error {
+	tag, err := normalize(ref)
+	if err != nil {
+		return err
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.registry[tag] = &remoteImage{id: "sha256:" + stringid.GenerateRandomID(), auth: auth}
+	return nil
+}
+
+/**
+ Add an image to daemon, as if it was pulled or built by someone else. Without reference, image is left untagged, as
+ a dangling image. Returns image ID.
+ */
+func (d *Daemon) AddImage(ref string, labels map[string]string) (string, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i := d.addImage("sha256:"+stringid.GenerateRandomID(), labels)
+	if ref == "" {
+		return i.ID, nil
+	}
+	tag, err := normalize(ref)
+	if err != nil {
+		delete(d.images, i.ID)
+		return "", err
+	}
+	d.tag(i, tag)
+	return i.ID, nil
+}
+
+/**
+ Must be called with lock held.
+ */
+func (d *Daemon) addImage(id string, labels map[string]string) *types.ImageInspect {
+	if i, ok := d.images[id]; ok {
+		return i
+	}
+	i := &types.ImageInspect{
+		ID:           id,
+		RepoTags:     []string{},
+		RepoDigests:  []string{},
+		Created:      time.Now().UTC().Format(time.RFC3339Nano),
+		Config:       &container.Config{Labels: labels},
+		Architecture: "amd64",
+		Os:           "linux",
+		Size:         1024,
+		VirtualSize:  1024,
+	}
+	d.images[id] = i
+	return i
+}
+
+/**
+ Must be called with lock held.
+ */
+func (d *Daemon) tag(i *types.ImageInspect, tag string) {
+	if previous, ok := d.tags[tag]; ok {
+		d.untag(d.images[previous], tag)
+	}
+	d.tags[tag] = i.ID
+	i.RepoTags = append(i.RepoTags, tag)
+	d.emit(events.ImageEventType, "tag", i.ID, map[string]string{"name": tag})
+}
+
+func (d *Daemon) untag(i *types.ImageInspect, tag string) {
+	delete(d.tags, tag)
+	tags := []string{}
+	for _, t := range i.RepoTags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	i.RepoTags = tags
+	d.emit(events.ImageEventType, "untag", i.ID, map[string]string{"name": tag})
+}
+
+/**
+ Check access to an image on registry
+ */
+func (d *Daemon) remote(ref, auth string) (string, *remoteImage, error) {
+	tag, err := normalize(ref)
+	if err != nil {
+		return "", nil, daemonError("%s", err.Error())
+	}
+	r, ok := d.registry[tag]
+	if !ok || (r.auth != "" && r.auth != auth) {
+		named, _ := reference.ParseNormalizedNamed(ref)
+		return "", nil, daemonError("pull access denied for %s, repository does not exist or may require 'docker login'", reference.FamiliarName(named))
+	}
+	return tag, r, nil
+}
+
+func progress(messages ...string) io.ReadCloser {
+	s := ""
+	for _, m := range messages {
+		s = s + fmt.Sprintf("{\"status\":%q}\r\n", m)
+	}
+	return ioutil.NopCloser(strings.NewReader(s))
+}
+
+func (d *Daemon) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	tag, r, err := d.remote(ref, options.RegistryAuth)
+	if err != nil {
+		return nil, err
+	}
+	i := d.addImage(r.id, r.labels)
+	if d.tags[tag] != i.ID {
+		d.tag(i, tag)
+	}
+	d.emit(events.ImageEventType, "pull", tag, nil)
+	return progress("Pulling from "+tag, "Status: Downloaded newer image for "+tag), nil
+}
+
+func (d *Daemon) ImageCreate(ctx context.Context, ref string, options types.ImageCreateOptions) (io.ReadCloser, error) {
+	return d.ImagePull(ctx, ref, types.ImagePullOptions{RegistryAuth: options.RegistryAuth})
+}
+
+func (d *Daemon) ImagePush(ctx context.Context, ref string, options types.ImagePushOptions) (io.ReadCloser, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i, err := d.image(ref)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := normalize(ref)
+	if err != nil {
+		return nil, daemonError("%s", err.Error())
+	}
+	if r, ok := d.registry[tag]; ok && r.auth != "" && r.auth != options.RegistryAuth {
+		return nil, daemonError("denied: requested access to the resource is denied")
+	}
+	d.registry[tag] = &remoteImage{id: i.ID, labels: i.Config.Labels, auth: options.RegistryAuth}
+	d.emit(events.ImageEventType, "push", tag, nil)
+	return progress("The push refers to a repository ["+tag+"]", "Pushed"), nil
+}
+
+/**
+ Build an image. Build context is read but ignored, image only gets requested labels and tags.
+ */
+func (d *Daemon) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	if buildContext != nil {
+		io.Copy(ioutil.Discard, buildContext)
+	}
+	tags := []string{}
+	for _, t := range options.Tags {
+		tag, err := normalize(t)
+		if err != nil {
+			return types.ImageBuildResponse{}, daemonError("%s", err.Error())
+		}
+		tags = append(tags, tag)
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i := d.addImage("sha256:"+stringid.GenerateRandomID(), options.Labels)
+	for _, t := range tags {
+		d.tag(i, t)
+	}
+	s := fmt.Sprintf("{\"stream\":\"Successfully built %s\\n\"}\r\n", stringid.TruncateID(i.ID))
+	for _, t := range tags {
+		s = s + fmt.Sprintf("{\"stream\":\"Successfully tagged %s\\n\"}\r\n", t)
+	}
+	return types.ImageBuildResponse{Body: ioutil.NopCloser(strings.NewReader(s)), OSType: "linux"}, nil
+}
+
+func (d *Daemon) ImageInspectWithRaw(ctx context.Context, ref string) (types.ImageInspect, []byte, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i, err := d.image(ref)
+	if err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+	return *i, nil, nil
+}
+
+func (d *Daemon) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	list := []types.ImageSummary{}
+	for _, i := range d.images {
+		if !options.Filters.MatchKVList("label", i.Config.Labels) {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339Nano, i.Created)
+		list = append(list, types.ImageSummary{
+			ID:          i.ID,
+			Created:     created.Unix(),
+			Labels:      i.Config.Labels,
+			RepoTags:    i.RepoTags,
+			RepoDigests: i.RepoDigests,
+			Size:        i.Size,
+			VirtualSize: i.VirtualSize,
+		})
+	}
+	return list, nil
+}
+
+func (d *Daemon) ImageHistory(ctx context.Context, ref string) ([]image.HistoryResponseItem, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i, err := d.image(ref)
+	if err != nil {
+		return nil, err
+	}
+	created, _ := time.Parse(time.RFC3339Nano, i.Created)
+	return []image.HistoryResponseItem{{ID: i.ID, Created: created.Unix(), Size: i.Size, Tags: i.RepoTags}}, nil
+}
+
+func (d *Daemon) ImageTag(ctx context.Context, source, target string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i, err := d.image(source)
+	if err != nil {
+		return err
+	}
+	tag, err := normalize(target)
+	if err != nil {
+		return daemonError("%s", err.Error())
+	}
+	d.tag(i, tag)
+	return nil
+}
+
+func (d *Daemon) ImageRemove(ctx context.Context, ref string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	i, err := d.image(ref)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []types.ImageDeleteResponseItem{}
+
+	if tag, err := normalize(ref); err == nil && d.tags[tag] == i.ID {
+		d.untag(i, tag)
+		deleted = append(deleted, types.ImageDeleteResponseItem{Untagged: tag})
+		if len(i.RepoTags) > 0 {
+			return deleted, nil
+		}
+	} else if len(i.RepoTags) > 1 && !options.Force {
+		return nil, daemonError("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories", stringid.TruncateID(i.ID))
+	}
+
+	for _, c := range d.containers {
+		if c.Image == i.ID && !options.Force {
+			return nil, daemonError("conflict: unable to delete %s (must be forced) - image is being used by stopped container %s", stringid.TruncateID(i.ID), stringid.TruncateID(c.ID))
+		}
+	}
+	for _, t := range i.RepoTags {
+		d.untag(i, t)
+		deleted = append(deleted, types.ImageDeleteResponseItem{Untagged: t})
+	}
+	delete(d.images, i.ID)
+	d.emit(events.ImageEventType, "delete", i.ID, nil)
+	return append(deleted, types.ImageDeleteResponseItem{Deleted: i.ID}), nil
+}
+
+/**
+ Search registry for images which name contains term
+ */
+func (d *Daemon) ImageSearch(ctx context.Context, term string, options types.ImageSearchOptions) ([]registry.SearchResult, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	results := []registry.SearchResult{}
+	names := map[string]bool{}
+	for tag := range d.registry {
+		named, err := reference.ParseNormalizedNamed(tag)
+		if err != nil {
+			continue
+		}
+		name := reference.FamiliarName(named)
+		if strings.Contains(name, term) && !names[name] {
+			names[name] = true
+			results = append(results, registry.SearchResult{Name: name})
+		}
+		if options.Limit > 0 && len(results) == options.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (d *Daemon) DistributionInspect(ctx context.Context, ref, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	_, r, err := d.remote(ref, encodedRegistryAuth)
+	if err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	inspect := registry.DistributionInspect{}
+	inspect.Descriptor.MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	inspect.Descriptor.Digest = digest.Digest(r.id)
+	return inspect, nil
+}
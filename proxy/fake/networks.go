@@ -0,0 +1,179 @@
+package fake
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stringid"
+	"golang.org/x/net/context"
+)
+
+/**
+ Lookup a network by ID, name or unique ID prefix. Must be called with lock held.
+ */
+func (d *Daemon) network(ref string) (*types.NetworkResource, error) {
+	if n, ok := d.networks[ref]; ok {
+		return n, nil
+	}
+	var found *types.NetworkResource
+	for id, n := range d.networks {
+		if n.Name == ref {
+			return n, nil
+		}
+		if strings.HasPrefix(id, ref) {
+			found = n
+		}
+	}
+	if found == nil {
+		return nil, notFound("network %s not found", ref)
+	}
+	return found, nil
+}
+
+/**
+ Create a network. Must be called with lock held.
+ */
+func (d *Daemon) createNetwork(name, driver string, labels map[string]string) *types.NetworkResource {
+	n := &types.NetworkResource{
+		Name:    name,
+		ID:      stringid.GenerateRandomID(),
+		Created: time.Now().UTC(),
+		Scope:   "local",
+		Driver:  driver,
+		Labels:  labels,
+		Options: map[string]string{},
+	}
+	d.networks[n.ID] = n
+	d.emit(events.NetworkEventType, "create", n.ID, map[string]string{"name": name, "type": driver})
+	return n
+}
+
+/**
+ Network with endpoints of containers connected to it. Must be called with lock held.
+ */
+func (d *Daemon) networkResource(n *types.NetworkResource) types.NetworkResource {
+	r := *n
+	r.Containers = map[string]types.EndpointResource{}
+	for _, c := range d.containers {
+		for _, e := range c.NetworkSettings.Networks {
+			if e.NetworkID == n.ID {
+				r.Containers[c.ID] = types.EndpointResource{Name: strings.TrimPrefix(c.Name, "/"), EndpointID: e.EndpointID}
+			}
+		}
+	}
+	return r
+}
+
+/**
+ Connect a container to a network, as daemon does on create and connect. Must be called with lock held.
+ */
+func (d *Daemon) connect(c *types.ContainerJSON, ref string, config *network.EndpointSettings) error {
+	n, err := d.network(ref)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.NetworkSettings.Networks[n.Name]; ok {
+		return daemonError("endpoint with name %s already exists in network %s", strings.TrimPrefix(c.Name, "/"), n.Name)
+	}
+	e := &network.EndpointSettings{}
+	if config != nil {
+		*e = *config
+	}
+	e.NetworkID, e.EndpointID = n.ID, stringid.GenerateRandomID()
+	c.NetworkSettings.Networks[n.Name] = e
+	return nil
+}
+
+func (d *Daemon) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	driver := options.Driver
+	if driver == "" {
+		driver = "bridge"
+	}
+	if driver != "bridge" {
+		return types.NetworkCreateResponse{}, daemonError("plugin %q not found", driver)
+	}
+	if options.CheckDuplicate {
+		for _, n := range d.networks {
+			if n.Name == name {
+				return types.NetworkCreateResponse{}, daemonError("network with name %s already exists", name)
+			}
+		}
+	}
+	n := d.createNetwork(name, driver, options.Labels)
+	n.Internal, n.Attachable = options.Internal, options.Attachable
+	return types.NetworkCreateResponse{ID: n.ID}, nil
+}
+
+func (d *Daemon) NetworkInspect(ctx context.Context, ref string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	n, err := d.network(ref)
+	if err != nil {
+		return types.NetworkResource{}, err
+	}
+	return d.networkResource(n), nil
+}
+
+func (d *Daemon) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	list := []types.NetworkResource{}
+	for _, n := range d.networks {
+		if !options.Filters.Match("name", n.Name) || !options.Filters.MatchKVList("label", n.Labels) {
+			continue
+		}
+		if options.Filters.Include("id") && !options.Filters.Match("id", n.ID) {
+			continue
+		}
+		list = append(list, d.networkResource(n))
+	}
+	return list, nil
+}
+
+func (d *Daemon) NetworkRemove(ctx context.Context, ref string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	n, err := d.network(ref)
+	if err != nil {
+		return err
+	}
+	if len(d.networkResource(n).Containers) > 0 {
+		return daemonError("error while removing network: network %s id %s has active endpoints", n.Name, n.ID)
+	}
+	delete(d.networks, n.ID)
+	d.emit(events.NetworkEventType, "destroy", n.ID, map[string]string{"name": n.Name, "type": n.Driver})
+	return nil
+}
+
+func (d *Daemon) NetworkConnect(ctx context.Context, ref, container string, config *network.EndpointSettings) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(container)
+	if err != nil {
+		return err
+	}
+	return d.connect(c, ref, config)
+}
+
+func (d *Daemon) NetworkDisconnect(ctx context.Context, ref, container string, force bool) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, err := d.container(container)
+	if err != nil {
+		return err
+	}
+	n, err := d.network(ref)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.NetworkSettings.Networks[n.Name]; !ok {
+		return daemonError("container %s is not connected to network %s", c.ID, n.Name)
+	}
+	delete(c.NetworkSettings.Networks, n.Name)
+	return nil
+}
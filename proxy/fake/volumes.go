@@ -0,0 +1,114 @@
+package fake
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stringid"
+	"golang.org/x/net/context"
+)
+
+/**
+ Must be called with lock held.
+ */
+func (d *Daemon) createVolume(name string, labels, options map[string]string) *types.Volume {
+	if name == "" {
+		name = stringid.GenerateRandomID()
+	}
+	if v, ok := d.volumes[name]; ok {
+		return v
+	}
+	v := &types.Volume{
+		Name:       name,
+		Driver:     "local",
+		Mountpoint: "/var/lib/docker/volumes/" + name + "/_data",
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Labels:     labels,
+		Options:    options,
+		Scope:      "local",
+	}
+	d.volumes[name] = v
+	d.emit(events.VolumeEventType, "create", name, map[string]string{"driver": v.Driver})
+	return v
+}
+
+/**
+ Set disk space used by a volume, as reported by DiskUsage
+ */
+func (d *Daemon) SetVolumeSize(name string, size int64) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.volumes[name]; !ok {
+		return notFound("No such volume: %s", name)
+	}
+	d.volumeSizes[name] = size
+	return nil
+}
+
+func (d *Daemon) volumeOrCreate(name string, options map[string]string) *types.Volume {
+	return d.createVolume(name, nil, options)
+}
+
+func (d *Daemon) VolumeCreate(ctx context.Context, options volumetypes.VolumesCreateBody) (types.Volume, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if options.Driver != "" && options.Driver != "local" {
+		return types.Volume{}, daemonError("create %s: error looking up volume plugin %s: plugin %q not found", options.Name, options.Driver, options.Driver)
+	}
+	return *d.createVolume(options.Name, options.Labels, options.DriverOpts), nil
+}
+
+func (d *Daemon) VolumeInspect(ctx context.Context, name string) (types.Volume, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	v, ok := d.volumes[name]
+	if !ok {
+		return types.Volume{}, notFound("No such volume: %s", name)
+	}
+	return *v, nil
+}
+
+func (d *Daemon) VolumeList(ctx context.Context, filter filters.Args) (volumetypes.VolumesListOKBody, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	list := volumetypes.VolumesListOKBody{Volumes: []*types.Volume{}, Warnings: []string{}}
+	for _, v := range d.volumes {
+		if !filter.MatchKVList("label", v.Labels) || !filter.Match("name", v.Name) {
+			continue
+		}
+		c := *v
+		list.Volumes = append(list.Volumes, &c)
+	}
+	return list, nil
+}
+
+func (d *Daemon) VolumeRemove(ctx context.Context, name string, force bool) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.volumes[name]; !ok {
+		if force {
+			return nil
+		}
+		return notFound("No such volume: %s", name)
+	}
+	users := []string{}
+	for _, c := range d.containers {
+		for _, m := range c.Mounts {
+			if m.Type == mount.TypeVolume && m.Name == name {
+				users = append(users, c.ID)
+			}
+		}
+	}
+	if len(users) > 0 {
+		return daemonError("unable to remove volume: remove %s: volume is in use - [%s]", name, strings.Join(users, ", "))
+	}
+	delete(d.volumes, name)
+	delete(d.volumeSizes, name)
+	d.emit(events.VolumeEventType, "destroy", name, nil)
+	return nil
+}
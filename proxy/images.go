@@ -2,31 +2,28 @@ package proxy
 
 import (
 	"net/http"
-	"golang.org/x/net/context"
 	"github.com/docker/docker/api/server/httputils"
 	"github.com/gorilla/mux"
-	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/ioutils"
 	"strings"
 	"github.com/docker/docker/api/types"
 	"io"
 	"fmt"
 	"github.com/docker/docker/api/types/filters"
+	"strconv"
 )
 
 
 func (p *Proxy) imagesList(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	imageFilters, err := filters.FromParam(r.Form.Get("filters"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -35,13 +32,12 @@ func (p *Proxy) imagesList(w http.ResponseWriter, r *http.Request) {
 		imageFilters.Add("reference", filterParam)
 	}
 
-	images, err := p.client.ImageList(context.Background(), types.ImageListOptions{
+	images, err := p.client.ImageList(r.Context(), types.ImageListOptions{
 		Filters: imageFilters,
 		All: httputils.BoolValue(r, "all"),
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -59,30 +55,81 @@ func (p *Proxy) imageInspect(w http.ResponseWriter, r *http.Request) {
 
 	name := mux.Vars(r)["name"]
 	if !p.ownsImage(name) {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, notFound("No such image: %s", name))
 		return
 	}
 
 
-	json, _, err := p.client.ImageInspectWithRaw(context.Background(), name)
+	json, _, err := p.client.ImageInspectWithRaw(r.Context(), name)
 	if err != nil {
-		if client.IsErrContainerNotFound(err) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		} else {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	httputils.WriteJSON(w, http.StatusOK, json) // TODO we could filter container by label to hide container created by another client
+}
+
+func (p *Proxy) imageHistory(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["name"]
+	if !p.ownsImage(name) {
+		writeError(w, r, notFound("No such image: %s", name))
+		return
+	}
+
+	history, err := p.client.ImageHistory(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	httputils.WriteJSON(w, http.StatusOK, history)
+}
+
+func (p *Proxy) imagesSearch(w http.ResponseWriter, r *http.Request) {
+
+	if err := httputils.ParseForm(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	term := r.Form.Get("term")
+	if !p.policy.allowSearch(term) {
+		writeError(w, r, denied("search is not authorized on registry for %s", term))
+		return
+	}
+
+	searchFilters, err := filters.FromParam(r.Form.Get("filters"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var limit int
+	if r.Form.Get("limit") != "" {
+		limit, err = strconv.Atoi(r.Form.Get("limit"))
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 	}
 
-	httputils.WriteJSON(w, http.StatusOK, json) // TODO we could filter container by label to hide container created by another client
+	results, err := p.client.ImageSearch(r.Context(), term, types.ImageSearchOptions{
+		RegistryAuth: r.Header.Get("X-Registry-Auth"),
+		Filters: searchFilters,
+		Limit: limit,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	httputils.WriteJSON(w, http.StatusOK, results)
 }
 
 func (p *Proxy) imagesCreate(w http.ResponseWriter, r *http.Request) {
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -90,7 +137,21 @@ func (p *Proxy) imagesCreate(w http.ResponseWriter, r *http.Request) {
 
 
 	if image == "" {
-		http.Error(w, "Import is not supported", http.StatusBadRequest)
+		writeError(w, r, badRequest("Import is not supported by Lancelot"))
+		return
+	}
+
+	quotas := []string{ImagesSizeQuota}
+	ref := image
+	if tag := r.Form.Get("tag"); tag != "" {
+		ref = image + ":" + tag
+	}
+	if p.isNewImage(ref) {
+		// pulling an image tenant already owns only updates it
+		quotas = append(quotas, ImagesQuota)
+	}
+	if err := p.checkQuota(r.Context(), quotas...); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -103,13 +164,12 @@ func (p *Proxy) imagesCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authEncoded := r.Header.Get("X-Registry-Auth")
-	reader, err := p.client.ImageCreate(context.Background(), image, types.ImageCreateOptions{
+	reader, err := p.client.ImageCreate(r.Context(), image, types.ImageCreateOptions{
 		RegistryAuth: authEncoded,
 		
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -118,11 +178,10 @@ func (p *Proxy) imagesCreate(w http.ResponseWriter, r *http.Request) {
 	io.Copy(output, reader)
 
 
-	// record both ID and all tags associated with image ID
-	inspect, _, err := p.client.ImageInspectWithRaw(context.Background(), image)
+	// record both ID and all tags associated with image ID. Response is already streamed, so we can only log errors
+	inspect, _, err := p.client.ImageInspectWithRaw(r.Context(), image)
 	if err != nil {
 		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	p.addImage(inspect.ID)
@@ -133,33 +192,44 @@ func (p *Proxy) imagesCreate(w http.ResponseWriter, r *http.Request) {
 
 func (p *Proxy) imageTag(w http.ResponseWriter, r *http.Request) {
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	name := mux.Vars(r)["name"]
 	if !p.ownsImage(name) {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, notFound("No such image: %s", name))
 		return
 	}
 
-	tag := r.Form.Get("tag")
-	if err := p.client.ImageTag(context.Background(), name, tag); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// API sends repository and tag as distinct parameters
+	tag := r.Form.Get("repo")
+	if t := r.Form.Get("tag"); t != "" {
+		tag = tag + ":" + t
+	}
+	source, _, err := p.client.ImageInspectWithRaw(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := p.checkTag(r.Context(), tag, source.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := p.client.ImageTag(r.Context(), name, tag); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	p.addImage(tag)
+	p.addTag(tag)
 	w.WriteHeader(http.StatusCreated)
 }
 
 func (p *Proxy) imagePush(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	tag := r.Form.Get("tag")
@@ -171,17 +241,20 @@ func (p *Proxy) imagePush(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !p.ownsImage(name) {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, notFound("No such image: %s", name))
+		return
+	}
+	if err := p.checkPush(r.Context(), name); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	authEncoded := r.Header.Get("X-Registry-Auth")
-	reader, err := p.client.ImagePush(context.Background(), name, types.ImagePushOptions{
+	reader, err := p.client.ImagePush(r.Context(), name, types.ImagePushOptions{
 		RegistryAuth: authEncoded,
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -189,4 +262,21 @@ func (p *Proxy) imagePush(w http.ResponseWriter, r *http.Request) {
 	output := ioutils.NewWriteFlusher(w)
 	defer output.Close()
 	io.Copy(output, reader)
+}
+
+/**
+ Distribution inspect is forwarded with caller's credentials, so registry will check caller can access the image.
+ */
+func (p *Proxy) distributionInspect(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["name"]
+
+	auth := r.Header.Get("X-Registry-Auth")
+	inspect, err := p.client.DistributionInspect(r.Context(), name, auth)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	httputils.WriteJSON(w, http.StatusOK, inspect)
 }
\ No newline at end of file
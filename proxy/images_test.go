@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"golang.org/x/net/context"
+)
+
+func TestPrivateImageRequiresCredentials(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	// image has been pulled on host by another tenant
+	if err := tp.daemon.Publish("acme/private:1.0", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.daemon.ImagePull(context.Background(), "acme/private:1.0", types.ImagePullOptions{RegistryAuth: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.expectError(tp.do("GET", "/images/acme/private:1.0/json", nil), http.StatusNotFound, "No such image")
+	tp.expectError(tp.do("GET", "/images/acme/private:1.0/history", nil), http.StatusNotFound, "No such image")
+	tp.expectError(tp.do("POST", "/images/acme/private:1.0/tag?repo=mine&tag=latest", nil), http.StatusNotFound, "No such image")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "acme/private:1.0"}), http.StatusNotFound, "pull access denied")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "acme/private:1.0"}, "X-Registry-Auth", "wrong"), http.StatusNotFound, "pull access denied")
+
+	tp.expect(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "acme/private:1.0"}, "X-Registry-Auth", "secret"), http.StatusCreated)
+	tp.expect(tp.do("GET", "/images/acme/private:1.0/json", nil), http.StatusOK)
+}
+
+func TestOnlyOwnedImagesAreListed(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	if _, err := tp.daemon.AddImage("foreign:latest", nil); err != nil {
+		t.Fatal(err)
+	}
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+
+	list := []types.ImageSummary{}
+	tp.decode(tp.do("GET", "/images/json", nil), http.StatusOK, &list)
+	if len(list) != 1 || len(list[0].RepoTags) != 1 || list[0].RepoTags[0] != "busybox:latest" {
+		t.Fatalf("expected only pulled image to be listed, got %v", list)
+	}
+
+	tp.expect(tp.do("POST", "/images/busybox:latest/tag?repo=mine&tag=1.0", nil), http.StatusCreated)
+	tp.expect(tp.do("GET", "/images/mine:1.0/json", nil), http.StatusOK)
+}
+
+func TestSearchIsRestrictedToRegistries(t *testing.T) {
+	tp := newTestProxy(t, Policy{SearchRegistries: []string{"docker.io"}})
+	defer tp.Close()
+
+	tp.expect(tp.do("GET", "/images/search?term=busybox", nil), http.StatusOK)
+	res := tp.do("GET", "/images/search?term=registry.example.com/busybox", nil)
+	if res.Header.Get(DeniedHeader) == "" {
+		t.Errorf("denial should be flagged with %s header", DeniedHeader)
+	}
+	tp.expectError(res, http.StatusForbidden, "search is not authorized")
+}
+
+func TestImageHistoryOfOwnedImages(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	if _, err := tp.daemon.AddImage("foreign:latest", nil); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.do("GET", "/images/foreign:latest/history", nil), http.StatusNotFound, "No such image: foreign:latest")
+
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	history := []image.HistoryResponseItem{}
+	tp.decode(tp.do("GET", "/images/busybox:latest/history", nil), http.StatusOK, &history)
+	if len(history) != 1 || len(history[0].Tags) != 1 || history[0].Tags[0] != "busybox:latest" {
+		t.Fatalf("expected history of pulled image, got %v", history)
+	}
+}
+
+func TestSearchResultsOnAllowedRegistry(t *testing.T) {
+	tp := newTestProxy(t, Policy{SearchRegistries: []string{"docker.io"}})
+	defer tp.Close()
+	if err := tp.daemon.Publish("acme/busybox-tools:1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []registry.SearchResult{}
+	tp.decode(tp.do("GET", "/images/search?term=busybox&limit=1", nil), http.StatusOK, &results)
+	if len(results) != 1 {
+		t.Fatalf("expected search limited to one result, got %v", results)
+	}
+	tp.expectError(tp.do("GET", "/images/search?term=quay.io/acme/busybox", nil), http.StatusForbidden, "search is not authorized on registry for quay.io/acme/busybox")
+}
+
+func TestDistributionInspectForwardsCallerAuth(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	if err := tp.daemon.Publish("acme/private:1.0", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.expectError(tp.do("GET", "/distribution/acme/private:1.0/json", nil), http.StatusNotFound, "pull access denied")
+	tp.expectError(tp.do("GET", "/distribution/acme/private:1.0/json", nil, "X-Registry-Auth", "wrong"), http.StatusNotFound, "pull access denied")
+
+	inspect := registry.DistributionInspect{}
+	tp.decode(tp.do("GET", "/distribution/acme/private:1.0/json", nil, "X-Registry-Auth", "secret"), http.StatusOK, &inspect)
+	if inspect.Descriptor.Digest == "" {
+		t.Fatalf("expected image descriptor, got %+v", inspect)
+	}
+}
@@ -1,65 +1,164 @@
 package proxy
 
 import (
-	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/versions"
 	"net/http"
-	"golang.org/x/net/context"
 	"github.com/docker/docker/api/server/httputils"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/pkg/ioutils"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"golang.org/x/net/context"
 )
 
 func (p *Proxy) ping(w http.ResponseWriter, r *http.Request) {
-	_, err := p.client.Ping(context.Background())
+	_, err := p.client.Ping(r.Context())
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	httputils.WriteJSON(w, http.StatusOK, &types.Ping{
-		APIVersion: api.DefaultVersion, // This is the API version we implement
-	})
+	// API-Version header, set on all responses, tells the API version we implement
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
 }
 
 func (p *Proxy) version(w http.ResponseWriter, r *http.Request) {
 
-	version, err := p.client.ServerVersion(context.Background())
+	daemon, err := p.client.ServerVersion(r.Context())
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
 	// Shall we filter output ?
-	httputils.WriteJSON(w, http.StatusOK, version)
+	version := VersionResponse{
+		Version: daemon,
+		Lancelot: ComponentVersion{
+			Version: Version,
+			APIVersion: p.MaxAPIVersion(),
+			MinAPIVersion: p.MinAPIVersion(),
+		},
+		Daemon: ComponentVersion{
+			Version: daemon.Version,
+			APIVersion: daemon.APIVersion,
+			MinAPIVersion: daemon.MinAPIVersion,
+		},
+	}
+	// API versions we actually serve
+	version.APIVersion = p.MaxAPIVersion()
+	version.MinAPIVersion = p.MinAPIVersion()
+	writeJSON(w, r, http.StatusOK, version)
 }
 
 func (p *Proxy) info(w http.ResponseWriter, r *http.Request) {
-	info, err := p.client.Info(context.Background())
+	info, err := p.client.Info(r.Context())
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	httputils.WriteJSON(w, http.StatusOK,
-	// not sure which info are actually useful/required and which ones can leak host config and break security
-	// so let just return a minimal set
-	types.Info {
-		ServerVersion: info.ServerVersion, // or proxy API version ?
-		ID: info.ID,
+	// tools probe info for platform and resources, but host ones are none of tenant's business. Report the
+	// tenant's view: platform as is, own resources counts, cgroup limits, and nothing identifying host.
+	tenant := types.Info {
+		Name: p.GetHostname(),
+		ServerVersion: info.ServerVersion,
+		OperatingSystem: info.OperatingSystem,
+		OSType: info.OSType,
 		Architecture: info.Architecture,
-		Containers: len(p.containers),
+		KernelVersion: info.KernelVersion,
+		Driver: info.Driver,
+		CgroupDriver: info.CgroupDriver,
+		LoggingDriver: info.LoggingDriver,
+		DefaultRuntime: info.DefaultRuntime,
+		Isolation: info.Isolation,
+		MemoryLimit: info.MemoryLimit,
+		SwapLimit: info.SwapLimit,
+		CPUCfsPeriod: info.CPUCfsPeriod,
+		CPUCfsQuota: info.CPUCfsQuota,
+		CPUShares: info.CPUShares,
+		CPUSet: info.CPUSet,
+		OomKillDisable: info.OomKillDisable,
+		IPv4Forwarding: info.IPv4Forwarding,
+		BridgeNfIptables: info.BridgeNfIptables,
+		BridgeNfIP6tables: info.BridgeNfIP6tables,
+		SecurityOptions: info.SecurityOptions,
+		SystemTime: info.SystemTime,
+		NCPU: info.NCPU,
+		MemTotal: info.MemTotal,
 		IndexServerAddress: info.IndexServerAddress,
-	})
+		Swarm: swarm.Info{LocalNodeState: swarm.LocalNodeStateInactive},
+	}
+	if info.RegistryConfig != nil {
+		// insecure registries CIDRs tell about host network
+		tenant.RegistryConfig = &registry.ServiceConfig{
+			IndexConfigs: info.RegistryConfig.IndexConfigs,
+			Mirrors: info.RegistryConfig.Mirrors,
+		}
+	}
+	if cpus := int(math.Ceil(p.limits.CPUs)); cpus > 0 && cpus < tenant.NCPU {
+		tenant.NCPU = cpus
+	}
+	if p.limits.Memory > 0 && p.limits.Memory < tenant.MemTotal {
+		tenant.MemTotal = p.limits.Memory
+	}
+	if err := p.countResources(r.Context(), &tenant); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, tenant)
+}
+
+/**
+ Count containers and images owned by tenant
+ */
+func (p *Proxy) countResources(ctx context.Context, info *types.Info) error {
+	containers, err := p.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	images, err := p.client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, c := range containers {
+		if !contains(p.containers, c.ID) {
+			continue
+		}
+		info.Containers++
+		switch c.State {
+		case "running":
+			info.ContainersRunning++
+		case "paused":
+			info.ContainersPaused++
+		default:
+			info.ContainersStopped++
+		}
+	}
+	owned := p.ownedImages()
+	for _, i := range images {
+		if isOwnedImage(owned, i) {
+			info.Images++
+		}
+	}
+	return nil
 }
 
 func (p *Proxy) events(w http.ResponseWriter, r *http.Request) {
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -67,17 +166,26 @@ func (p *Proxy) events(w http.ResponseWriter, r *http.Request) {
 	until := r.Form.Get("until")
 	args, err := filters.FromParam(r.Form.Get("filters"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	// container filter can reference containers by name, which are namespaced
+	for _, c := range args.Get("container") {
+		if id, err := p.ownsContainer(c); err == nil && id != c {
+			args.Del("container", c)
+			args.Add("container", id)
+		}
+	}
 
-	msg, error := p.client.Events(context.Background(), types.EventsOptions{
+	msg, error := p.client.Events(r.Context(), types.EventsOptions{
 		Since: since,
 		Until: until,
 		Filters: args,
 	})
 
+	// Old versions of the API only support container events
+	onlyContainerEvents := versions.LessThan(apiVersion(r), "1.22")
+
 	w.Header().Set("Content-Type", "application/json")
 	output := ioutils.NewWriteFlusher(w)
 	output.Flush()
@@ -85,16 +193,62 @@ func (p *Proxy) events(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case ev := <-msg:
+			if onlyContainerEvents && ev.Type != events.ContainerEventType {
+				continue
+			}
+			if !p.ownsEvent(ev) {
+				continue
+			}
+			p.clientEvent(&ev)
 			if err := enc.Encode(ev); err != nil {
 				fmt.Println(err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-		case e := <-error:
+		case err := <-error:
+			// response is already streamed, client will only see the stream end
 			fmt.Println(err.Error())
-			http.Error(w, e.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
+
+/**
+ Tell whether an event is about a resource tenant owns, so tenants don't see each other's activity. Daemon and other
+ events are not reported. Containers, volumes and networks can emit events before they're recorded, like on create,
+ so a namespaced name is trusted as well. An unnamed container create event can still be missed.
+ */
+func (p *Proxy) ownsEvent(ev events.Message) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	namespaced := func(name string) bool {
+		return p.tenant != "" && strings.HasPrefix(name, p.namePrefix())
+	}
+	name := ev.Actor.Attributes["name"]
+	switch ev.Type {
+	case events.ContainerEventType:
+		return contains(p.containers, ev.Actor.ID) || namespaced(name)
+	case events.VolumeEventType:
+		return contains(p.volumes, ev.Actor.ID) || namespaced(ev.Actor.ID)
+	case events.NetworkEventType:
+		return contains(p.networks, ev.Actor.ID) || namespaced(name)
+	case events.ImageEventType:
+		owned := func(ref string) bool {
+			ref = familiarTag(ref)
+			for _, list := range [][]string{p.images, p.built, p.tags} {
+				for _, i := range list {
+					if familiarTag(i) == ref {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		// tag and untag are about an image ID other tenants may use, name being the tag
+		if ev.Action == "tag" || ev.Action == "untag" {
+			return owned(name)
+		}
+		return owned(ev.Actor.ID)
+	}
+	return false
+}
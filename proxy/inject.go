@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// variables available to injected values, besides Lancelot environment
+const (
+	TenantVariable  = "LANCELOT_TENANT"
+	CreatedVariable = "LANCELOT_CREATED"
+)
+
+// InjectPolicy sets labels and environment on resources created by tenant, so they can be traced back to the
+// pipeline which created them. Values can reference Lancelot environment variables, like `${BUILD_NUMBER}`, as well as
+// `${LANCELOT_TENANT}` and `${LANCELOT_CREATED}` for tenant name and resource creation time.
+type InjectPolicy struct {
+	// Labels set on containers, images built, volumes and networks, overriding client ones
+	Labels map[string]string `json:"labels,omitempty"`
+	// Environment variables set on containers and passed as build args, overriding client ones
+	Env map[string]string `json:"env,omitempty"`
+	// Environment variables client can't set, as names or patterns like `LD_*`
+	DenyEnv []string `json:"denyEnv,omitempty"`
+}
+
+func (i InjectPolicy) validate() error {
+	for _, pattern := range i.DenyEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("Invalid environment variable pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+func (i InjectPolicy) deniesEnv(name string) bool {
+	for _, pattern := range i.DenyEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ Expand variables in an injected value
+ */
+func (p *Proxy) expand(value string, created time.Time) string {
+	return os.Expand(value, func(name string) string {
+		switch name {
+		case TenantVariable:
+			return p.GetTenant()
+		case CreatedVariable:
+			return created.UTC().Format(time.RFC3339)
+		}
+		return os.Getenv(name)
+	})
+}
+
+/**
+ Add injected labels to those set on a resource
+ */
+func (p *Proxy) injectLabels(labels map[string]string) map[string]string {
+	if len(p.policy.Inject.Labels) == 0 {
+		return labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	now := time.Now()
+	for k, v := range p.policy.Inject.Labels {
+		labels[k] = p.expand(v, now)
+	}
+	return labels
+}
+
+/**
+ Check environment variable names set by client against denylist
+ */
+func (p *Proxy) checkEnv(names ...string) error {
+	for _, name := range names {
+		if p.policy.Inject.deniesEnv(name) {
+			return denied("environment variable %s is not authorized", name)
+		}
+	}
+	return nil
+}
+
+/**
+ Check environment set by client, as `NAME=value` entries, and add injected variables
+ */
+func (p *Proxy) injectEnv(env []string) ([]string, error) {
+	names := []string{}
+	for _, e := range env {
+		names = append(names, strings.SplitN(e, "=", 2)[0])
+	}
+	if err := p.checkEnv(names...); err != nil {
+		return nil, err
+	}
+	if len(p.policy.Inject.Env) == 0 {
+		return env, nil
+	}
+
+	injected := []string{}
+	for k := range p.policy.Inject.Env {
+		injected = append(injected, k)
+	}
+	sort.Strings(injected)
+
+	forwarded := []string{}
+	for i, e := range env {
+		if _, ok := p.policy.Inject.Env[names[i]]; !ok {
+			forwarded = append(forwarded, e)
+		}
+	}
+	now := time.Now()
+	for _, k := range injected {
+		forwarded = append(forwarded, k+"="+p.expand(p.policy.Inject.Env[k], now))
+	}
+	return forwarded, nil
+}
+
+/**
+ Check build args set by client against denylist, and add injected variables
+ */
+func (p *Proxy) injectBuildArgs(args map[string]*string) (map[string]*string, error) {
+	names := []string{}
+	for k := range args {
+		names = append(names, k)
+	}
+	if err := p.checkEnv(names...); err != nil {
+		return nil, err
+	}
+	if len(p.policy.Inject.Env) == 0 {
+		return args, nil
+	}
+	if args == nil {
+		args = map[string]*string{}
+	}
+	now := time.Now()
+	for k, v := range p.policy.Inject.Env {
+		value := p.expand(v, now)
+		args[k] = &value
+	}
+	return args, nil
+}
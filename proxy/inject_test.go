@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+func injectingProxy(t *testing.T) *testProxy {
+	os.Setenv("BUILD_NUMBER", "42")
+	tp := newTestProxy(t, Policy{Inject: InjectPolicy{
+		Labels: map[string]string{
+			"ci.build":   "${BUILD_NUMBER}",
+			"ci.tenant":  "${LANCELOT_TENANT}",
+			"ci.created": "${LANCELOT_CREATED}",
+		},
+		Env:     map[string]string{"HTTP_PROXY": "http://proxy:3128", "BUILD_NUMBER": "${BUILD_NUMBER}"},
+		DenyEnv: []string{"LD_*", "DOCKER_HOST"},
+	}})
+	tp.proxy.SetTenant("ci")
+	return tp
+}
+
+func checkInjectedLabels(t *testing.T, labels map[string]string) {
+	if labels["ci.build"] != "42" || labels["ci.tenant"] != "ci" {
+		t.Fatalf("expected labels to be injected, got %v", labels)
+	}
+	if _, err := time.Parse(time.RFC3339, labels["ci.created"]); err != nil {
+		t.Fatalf("expected creation time label, got %v", labels)
+	}
+}
+
+func TestContainersAreInjected(t *testing.T) {
+	tp := injectingProxy(t)
+	defer tp.Close()
+
+	body := container.ContainerCreateCreatedBody{}
+	tp.decode(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":  "busybox",
+		"Env":    []string{"FOO=bar", "BUILD_NUMBER=1"},
+		"Labels": map[string]string{"ci.build": "1"},
+	}), http.StatusCreated, &body)
+
+	json, err := tp.daemon.ContainerInspect(context.Background(), body.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInjectedLabels(t, json.Config.Labels)
+	env := json.Config.Env
+	if len(env) != 3 || env[0] != "FOO=bar" || env[1] != "BUILD_NUMBER=42" || env[2] != "HTTP_PROXY=http://proxy:3128" {
+		t.Fatalf("expected environment to be injected, got %v", env)
+	}
+
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image": "busybox",
+		"Env":   []string{"LD_PRELOAD=/tmp/evil.so"},
+	}), http.StatusForbidden, "environment variable LD_PRELOAD is not authorized")
+
+	id := tp.run("busybox")
+	tp.expectError(tp.do("POST", "/containers/"+id+"/exec", map[string]interface{}{
+		"Cmd": []string{"sh"},
+		"Env": []string{"DOCKER_HOST=tcp://host:2375"},
+	}), http.StatusForbidden, "environment variable DOCKER_HOST is not authorized")
+}
+
+func TestVolumesAndImagesAreInjected(t *testing.T) {
+	tp := injectingProxy(t)
+	defer tp.Close()
+
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "data"}), http.StatusCreated)
+	volume, err := tp.daemon.VolumeInspect(context.Background(), "ci_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInjectedLabels(t, volume.Labels)
+
+	tp.expect(tp.do("POST", "/build?t=app", nil), http.StatusOK)
+	image, _, err := tp.daemon.ImageInspectWithRaw(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInjectedLabels(t, image.Config.Labels)
+
+	tp.expectError(tp.do("POST", "/build?t=app&buildargs="+url.QueryEscape(`{"LD_LIBRARY_PATH":"/tmp"}`), nil), http.StatusForbidden, "environment variable LD_LIBRARY_PATH is not authorized")
+}
+
+func TestNetworksAreInjected(t *testing.T) {
+	tp := injectingProxy(t)
+	defer tp.Close()
+	tp.proxy.policy.Profile = ComposeProfile
+
+	tp.expect(tp.do("POST", "/networks/create", map[string]interface{}{"Name": "net"}), http.StatusCreated)
+	n, err := tp.daemon.NetworkInspect(context.Background(), "ci_net", types.NetworkInspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInjectedLabels(t, n.Labels)
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CgroupLimits are the CPU and memory limits sidecars share. Zero means no limit.
+type CgroupLimits struct {
+	CPUs   float64
+	Memory int64
+}
+
+// memory limit cgroup v1 reports when there's none, rounded to page size
+const unlimitedMemory = int64(1) << 62
+
+/**
+ Read limits of our own cgroup, as mounted in container on root (usually /sys/fs/cgroup), supporting cgroup v1 and v2
+ */
+func ReadCgroupLimits(root string) CgroupLimits {
+	limits := CgroupLimits{}
+
+	// cgroup v2: cpu.max is "$QUOTA $PERIOD", memory.max a byte count, both using "max" for no limit
+	if cpu, err := readCgroupFile(root, "cpu.max"); err == nil {
+		if f := strings.Fields(cpu); len(f) == 2 && f[0] != "max" {
+			limits.CPUs = cpus(f[0], f[1])
+		}
+		if memory, err := readCgroupFile(root, "memory.max"); err == nil && memory != "max" {
+			limits.Memory, _ = strconv.ParseInt(memory, 10, 64)
+		}
+		return limits
+	}
+
+	// cgroup v1: cfs quota is -1 when there's no limit. cpu controller might be mounted along with cpuacct
+	for _, controller := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := readCgroupFile(root, controller, "cpu.cfs_quota_us")
+		if err != nil {
+			continue
+		}
+		if period, err := readCgroupFile(root, controller, "cpu.cfs_period_us"); err == nil && quota != "-1" {
+			limits.CPUs = cpus(quota, period)
+		}
+		break
+	}
+	if memory, err := readCgroupFile(root, "memory", "memory.limit_in_bytes"); err == nil {
+		if m, err := strconv.ParseInt(memory, 10, 64); err == nil && m < unlimitedMemory {
+			limits.Memory = m
+		}
+	}
+	return limits
+}
+
+func readCgroupFile(elem ...string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(elem...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func cpus(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
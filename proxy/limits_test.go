@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		f := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadCgroupLimits(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		files    map[string]string
+		expected CgroupLimits
+	}{
+		{"v2", map[string]string{"cpu.max": "150000 100000", "memory.max": "536870912"}, CgroupLimits{1.5, 536870912}},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000", "memory.max": "max"}, CgroupLimits{}},
+		{"v1", map[string]string{
+			"cpu,cpuacct/cpu.cfs_quota_us":  "200000",
+			"cpu,cpuacct/cpu.cfs_period_us": "100000",
+			"memory/memory.limit_in_bytes":  "1073741824",
+		}, CgroupLimits{2, 1073741824}},
+		{"v1 unlimited", map[string]string{
+			"cpu/cpu.cfs_quota_us":         "-1",
+			"cpu/cpu.cfs_period_us":        "100000",
+			"memory/memory.limit_in_bytes": "9223372036854771712",
+		}, CgroupLimits{}},
+	} {
+		root := writeCgroupFiles(t, test.files)
+		defer os.RemoveAll(root)
+		if limits := ReadCgroupLimits(root); limits != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, limits)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// separates tenant from name in container and volume names sent to daemon, like `tenant_db`
+const nameSeparator = "_"
+
+// valid tenant names: container name characters, but the separator, so `ci` and `ci_x` can't share a name like `ci_x_db`
+var tenantName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*$`)
+
+/**
+ Namespace container and volume names, so tenants sharing a host don't collide. Tenant has to be a valid container
+ name without separator. Empty means no namespacing.
+ */
+func (p *Proxy) SetTenant(tenant string) error {
+	if tenant != "" && !tenantName.MatchString(tenant) {
+		return fmt.Errorf("Invalid tenant %s, only [a-zA-Z0-9][a-zA-Z0-9.-] are allowed", tenant)
+	}
+	fmt.Printf("namespacing container and volume names for tenant %s\n", tenant)
+	p.tenant = tenant
+	return nil
+}
+
+func (p *Proxy) GetTenant() string {
+	return p.tenant
+}
+
+func (p *Proxy) namePrefix() string {
+	if p.tenant == "" {
+		return ""
+	}
+	return p.tenant + nameSeparator
+}
+
+/**
+ Name of a tenant container or volume, as known by daemon
+ */
+func (p *Proxy) daemonName(name string) string {
+	if name == "" || p.tenant == "" {
+		return name
+	}
+	slash := strings.HasPrefix(name, "/")
+	name = p.namePrefix() + strings.TrimPrefix(name, "/")
+	if slash {
+		name = "/" + name
+	}
+	return name
+}
+
+/**
+ Name of a tenant container or volume, as known by client
+ */
+func (p *Proxy) clientName(name string) string {
+	if p.tenant == "" {
+		return name
+	}
+	if strings.HasPrefix(name, "/") {
+		return "/" + strings.TrimPrefix(name[1:], p.namePrefix())
+	}
+	return strings.TrimPrefix(name, p.namePrefix())
+}
+
+/**
+ Rewrite names in a reference made of names separated by `/` and `:`, like link `/tenant_db:/tenant_web/db`
+ */
+func (p *Proxy) clientRef(ref string) string {
+	if p.tenant == "" {
+		return ref
+	}
+	parts := strings.Split(ref, ":")
+	for i, part := range parts {
+		names := strings.Split(part, "/")
+		for j, n := range names {
+			names[j] = p.clientName(n)
+		}
+		parts[i] = strings.Join(names, "/")
+	}
+	return strings.Join(parts, ":")
+}
+
+/**
+ Rewrite names in a container listing, as known by client
+ */
+func (p *Proxy) clientContainer(c *types.Container) {
+	for i, n := range c.Names {
+		c.Names[i] = p.clientRef(n)
+	}
+	for i := range c.Mounts {
+		c.Mounts[i].Name = p.clientName(c.Mounts[i].Name)
+	}
+	c.HostConfig.NetworkMode = p.clientName(c.HostConfig.NetworkMode)
+	if c.NetworkSettings != nil {
+		c.NetworkSettings.Networks = p.clientEndpoints(c.NetworkSettings.Networks)
+	}
+}
+
+func (p *Proxy) clientEndpoints(endpoints map[string]*network.EndpointSettings) map[string]*network.EndpointSettings {
+	if endpoints == nil {
+		return nil
+	}
+	renamed := map[string]*network.EndpointSettings{}
+	for name, e := range endpoints {
+		renamed[p.clientName(name)] = e
+	}
+	return renamed
+}
+
+/**
+ Rewrite names in a container inspection, as known by client
+ */
+func (p *Proxy) clientContainerJSON(json *types.ContainerJSON) {
+	json.Name = p.clientName(json.Name)
+	for i := range json.Mounts {
+		json.Mounts[i].Name = p.clientName(json.Mounts[i].Name)
+	}
+	if json.ContainerJSONBase != nil && json.HostConfig != nil {
+		for i, l := range json.HostConfig.Links {
+			json.HostConfig.Links[i] = p.clientRef(l)
+		}
+		for i, b := range json.HostConfig.Binds {
+			json.HostConfig.Binds[i] = p.clientRef(b)
+		}
+		for i, v := range json.HostConfig.VolumesFrom {
+			json.HostConfig.VolumesFrom[i] = p.clientName(v)
+		}
+		json.HostConfig.NetworkMode = container.NetworkMode(p.clientName(string(json.HostConfig.NetworkMode)))
+	}
+	if json.NetworkSettings != nil {
+		json.NetworkSettings.Networks = p.clientEndpoints(json.NetworkSettings.Networks)
+	}
+}
+
+/**
+ Rewrite names in an event, as known by client
+ */
+func (p *Proxy) clientEvent(ev *events.Message) {
+	if p.tenant == "" {
+		return
+	}
+	// attributes might be shared with other subscribers
+	attributes := map[string]string{}
+	for k, v := range ev.Actor.Attributes {
+		attributes[k] = v
+	}
+	ev.Actor.Attributes = attributes
+	if name, ok := ev.Actor.Attributes["name"]; ok {
+		ev.Actor.Attributes["name"] = p.clientName(name)
+	}
+	if ev.Type == events.VolumeEventType {
+		ev.Actor.ID = p.clientName(ev.Actor.ID)
+	}
+	if c, ok := ev.Actor.Attributes["container"]; ok && ev.Type == events.VolumeEventType {
+		ev.Actor.Attributes["container"] = p.clientName(c)
+	}
+}
+
+/**
+ Rewrite names in a network, as known by client
+ */
+func (p *Proxy) clientNetwork(n types.NetworkResource) types.NetworkResource {
+	n.Name = p.clientName(n.Name)
+	containers := map[string]types.EndpointResource{}
+	for id, e := range n.Containers {
+		e.Name = p.clientName(e.Name)
+		containers[id] = e
+	}
+	n.Containers = containers
+	return n
+}
+
+/**
+ Remove tenant prefix from names in a daemon error message, like a name conflict
+ */
+func (p *Proxy) clientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ours := err.(statusError); ours || p.tenant == "" || !strings.Contains(err.Error(), p.namePrefix()) {
+		return err
+	}
+	return errors.New(strings.Replace(err.Error(), p.namePrefix(), "", -1))
+}
+
+/**
+ Name of a volume referenced by client, as known by daemon. Anonymous volumes are not namespaced.
+ */
+func (p *Proxy) daemonVolume(name string) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if contains(p.volumes, name) {
+		return name
+	}
+	return p.daemonName(name)
+}
+
+/**
+ Rewrite a `name` filter, matched by daemon against container names, so it applies to namespaced names
+ */
+func (p *Proxy) nameFilter(args filters.Args) filters.Args {
+	if p.tenant == "" || !args.Include("name") {
+		return args
+	}
+	for _, n := range args.Get("name") {
+		rewritten := n
+		switch {
+		case strings.HasPrefix(n, "^/"):
+			rewritten = "^/" + p.namePrefix() + n[2:]
+		case strings.HasPrefix(n, "^"):
+			rewritten = "^" + p.namePrefix() + n[1:]
+		}
+		if rewritten != n {
+			args.Del("name", n)
+			args.Add("name", rewritten)
+		}
+	}
+	return args
+}
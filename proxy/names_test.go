@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"golang.org/x/net/context"
+)
+
+func TestNamesAreNamespaced(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.proxy.SetTenant("ci-42")
+
+	// another tenant uses the same names
+	if _, err := tp.daemon.AddImage("busybox", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.daemon.ContainerCreate(context.Background(), &container.Config{Image: "busybox"}, nil, nil, "db"); err != nil {
+		t.Fatal(err)
+	}
+
+	db := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"data:/data"}},
+	}, "?name=db")
+	tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Links": []string{"db"}, "VolumesFrom": []string{"db:ro"}},
+	}, "?name=web")
+	tp.expectError(tp.do("POST", "/containers/create?name=db", map[string]interface{}{"Image": "busybox"}), http.StatusConflict, `The container name "/db" is already in use`)
+
+	upstream, err := tp.daemon.ContainerInspect(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream.Name != "/ci-42_db" || upstream.Mounts[0].Name != "ci-42_data" {
+		t.Fatalf("expected names to be prefixed on daemon, got %s and %s", upstream.Name, upstream.Mounts[0].Name)
+	}
+
+	json := types.ContainerJSON{}
+	tp.decode(tp.do("GET", "/containers/web/json", nil), http.StatusOK, &json)
+	if json.Name != "/web" || len(json.HostConfig.Links) != 1 || json.HostConfig.Links[0] != "db:db" || json.HostConfig.VolumesFrom[0] != "db:ro" {
+		t.Fatalf("expected names without prefix, got %s %v %v", json.Name, json.HostConfig.Links, json.HostConfig.VolumesFrom)
+	}
+
+	list := []types.Container{}
+	filter := url.QueryEscape(`{"name":{"^/db$":true}}`)
+	tp.decode(tp.do("GET", "/containers/json?all=1&filters="+filter, nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != db || list[0].Names[0] != "/db" {
+		t.Fatalf("expected own db container only, got %v", list)
+	}
+
+	volumes := volumetypes.VolumesListOKBody{}
+	tp.decode(tp.do("GET", "/volumes", nil), http.StatusOK, &volumes)
+	if len(volumes.Volumes) != 1 || volumes.Volumes[0].Name != "data" {
+		t.Fatalf("expected volume name without prefix, got %v", volumes.Volumes)
+	}
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "cache"}), http.StatusCreated)
+	tp.expect(tp.do("DELETE", "/volumes/cache", nil), http.StatusNoContent)
+}
+
+func TestTenantNamesCantHoldSeparator(t *testing.T) {
+	p := &Proxy{}
+	if err := p.SetTenant("ci_x"); err == nil {
+		t.Fatal("expected tenant with separator to be rejected")
+	}
+	if err := p.SetTenant("ci-x.1"); err != nil || p.GetTenant() != "ci-x.1" {
+		t.Fatalf("expected tenant to be set, got %v", err)
+	}
+}
+
+func TestEventsAreFilteredByTenant(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.proxy.SetTenant("ci")
+	ctx := context.Background()
+
+	// another tenant's activity
+	if _, err := tp.daemon.AddImage("busybox", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: "busybox"}, nil, nil, "other_db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.daemon.VolumeCreate(ctx, volumetypes.VolumesCreateBody{Name: "other_data"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.daemon.ImageTag(ctx, "busybox", "other/app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	db := tp.create(map[string]interface{}{"Image": "busybox"}, "?name=db")
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "data"}), http.StatusCreated)
+	tp.expect(tp.do("POST", "/images/busybox/tag?repo=mine&tag=1", nil), http.StatusCreated)
+
+	res := tp.do("GET", "/events?since=0", nil)
+	defer res.Body.Close()
+	decoder := json.NewDecoder(res.Body)
+	seen := []string{}
+	for len(seen) == 0 || seen[len(seen)-1] != "image tag mine:1" {
+		ev := events.Message{}
+		if err := decoder.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type == events.ImageEventType {
+			// tag events are on image ID
+			seen = append(seen, strings.Join([]string{ev.Type, ev.Action, ev.Actor.Attributes["name"]}, " "))
+			continue
+		}
+		seen = append(seen, strings.TrimSpace(strings.Join([]string{ev.Type, ev.Action, ev.Actor.ID, ev.Actor.Attributes["name"]}, " ")))
+	}
+	if !contains(seen, "container create "+db+" db") || !contains(seen, "volume create data") {
+		t.Fatalf("expected own events, got %v", seen)
+	}
+	for _, s := range seen {
+		if strings.Contains(s, "other") {
+			t.Fatalf("expected own events only, got %v", seen)
+		}
+	}
+}
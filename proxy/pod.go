@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// labels set by kubelet docker-shim on pod containers
+const (
+	podUIDLabel        = "io.kubernetes.pod.uid"
+	podNameLabel       = "io.kubernetes.pod.name"
+	containerTypeLabel = "io.kubernetes.docker.type"
+	podSandbox         = "podsandbox"
+)
+
+// Pod is the Kubernetes pod sandbox Lancelot runs in, when deployed on a docker-shim node
+type Pod struct {
+	UID  string
+	Name string
+	// Pause container, holding pod namespaces
+	Sandbox string
+	// Pod cgroup, as set by kubelet on pause container
+	CgroupParent string
+}
+
+/**
+ Detect the pod our container belongs to, from kubelet labels, and its pause container.
+ */
+func (p *Proxy) DetectPod() (*Pod, error) {
+	if p.cgroup.ContainerID == "" {
+		return nil, errors.New("unknown container, can't detect pod")
+	}
+	self, err := p.client.ContainerInspect(context.Background(), p.cgroup.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	if self.Config == nil || self.Config.Labels[podUIDLabel] == "" {
+		return nil, errors.New("not running in a Kubernetes pod")
+	}
+	uid := self.Config.Labels[podUIDLabel]
+
+	args := filters.NewArgs()
+	args.Add("label", podUIDLabel+"="+uid)
+	args.Add("label", containerTypeLabel+"="+podSandbox)
+	sandboxes, err := p.client.ContainerList(context.Background(), types.ContainerListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sandboxes) != 1 {
+		return nil, errors.Errorf("expected a single running sandbox for pod %s, found %d", uid, len(sandboxes))
+	}
+
+	pause, err := p.client.ContainerInspect(context.Background(), sandboxes[0].ID)
+	if err != nil {
+		return nil, err
+	}
+	pod := &Pod{
+		UID:     uid,
+		Name:    self.Config.Labels[podNameLabel],
+		Sandbox: pause.ID,
+	}
+	if pause.HostConfig != nil {
+		pod.CgroupParent = pause.HostConfig.CgroupParent
+	}
+	return pod, nil
+}
+
+/**
+ Run sidecars inside pod sandbox: pod cgroup, network and IPC namespaces. Pod sandbox is then the parent container.
+ */
+func (p *Proxy) SetPod(pod *Pod) {
+	fmt.Printf("running in Kubernetes pod %s (%s), sandbox %s\n", pod.Name, pod.UID, pod.Sandbox)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.pod = pod
+	p.cgroup.ContainerID = pod.Sandbox
+	if pod.CgroupParent != "" {
+		p.cgroup.Parent = pod.CgroupParent
+	}
+}
+
+func (p *Proxy) InPod() bool {
+	return p.pod != nil
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+// pod cgroup set by kubelet on pause container
+const testPodCgroup = "/kubepods/burstable/podu1"
+
+// create and start a container with kubelet labels
+func (tp *testProxy) podContainer(uid, kind string, running bool, hostConfig *container.HostConfig) string {
+	tp.t.Helper()
+	ctx := context.Background()
+	labels := map[string]string{podUIDLabel: uid, podNameLabel: "agent-" + uid, containerTypeLabel: kind}
+	c, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: "busybox", Labels: labels}, hostConfig, nil, "")
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	if running {
+		if err := tp.daemon.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+			tp.t.Fatal(err)
+		}
+	}
+	return c.ID
+}
+
+// test proxy running in a container of pod u1
+func newPodTestProxy(t *testing.T) (*testProxy, string) {
+	tp := newTestProxy(t, Policy{})
+	if _, err := tp.daemon.ImagePull(context.Background(), "busybox", types.ImagePullOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	self := tp.podContainer("u1", "container", true, nil)
+	cgroup := CgroupOverride(testCgroup)
+	cgroup.ContainerID = self
+	tp.proxy.SetCgroup(cgroup)
+	return tp, self
+}
+
+func TestDetectPod(t *testing.T) {
+	tp, _ := newPodTestProxy(t)
+	defer tp.Close()
+
+	// previous sandbox of the pod, stopped, and sandbox of another pod
+	tp.podContainer("u1", podSandbox, false, nil)
+	tp.podContainer("u2", podSandbox, true, &container.HostConfig{CgroupParent: "/kubepods/podu2"})
+	pause := tp.podContainer("u1", podSandbox, true, &container.HostConfig{CgroupParent: testPodCgroup})
+
+	pod, err := tp.proxy.DetectPod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.UID != "u1" || pod.Name != "agent-u1" || pod.Sandbox != pause || pod.CgroupParent != testPodCgroup {
+		t.Fatalf("expected running sandbox of pod u1, got %+v", pod)
+	}
+
+	tp.proxy.SetPod(pod)
+	if !tp.proxy.InPod() || tp.proxy.GetCgroup() != testPodCgroup {
+		t.Fatalf("expected sidecars to be placed in pod cgroup, got %s", tp.proxy.GetCgroup())
+	}
+}
+
+func TestDetectPodWithoutSandbox(t *testing.T) {
+	tp, _ := newPodTestProxy(t)
+	defer tp.Close()
+	tp.podContainer("u1", podSandbox, false, nil)
+
+	if _, err := tp.proxy.DetectPod(); err == nil || !strings.Contains(err.Error(), "expected a single running sandbox for pod u1, found 0") {
+		t.Fatalf("expected missing sandbox to be reported, got %v", err)
+	}
+}
+
+func TestDetectPodOutOfKubernetes(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	self := tp.run("busybox")
+	cgroup := CgroupOverride(testCgroup)
+	cgroup.ContainerID = self
+	tp.proxy.SetCgroup(cgroup)
+
+	if _, err := tp.proxy.DetectPod(); err == nil || !strings.Contains(err.Error(), "not running in a Kubernetes pod") {
+		t.Fatalf("expected container without kubelet labels to be out of a pod, got %v", err)
+	}
+}
+
+func TestSidecarsJoinPodSandbox(t *testing.T) {
+	tp, _ := newPodTestProxy(t)
+	defer tp.Close()
+	pause := tp.podContainer("u1", podSandbox, true, &container.HostConfig{CgroupParent: testPodCgroup})
+	pod, err := tp.proxy.DetectPod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.proxy.SetPod(pod)
+
+	tp.create(map[string]interface{}{"Image": "busybox"}, "?name=db")
+	id := tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{
+			"NetworkMode":  "bridge",
+			"IpcMode":      "host",
+			"CgroupParent": "/elsewhere",
+			"Links":        []string{"db"},
+		},
+	}, "")
+	h := tp.hostConfig(id)
+	if string(h.NetworkMode) != "container:"+pause || string(h.IpcMode) != "container:"+pause {
+		t.Fatalf("expected sidecar to join pod sandbox namespaces, got %s and %s", h.NetworkMode, h.IpcMode)
+	}
+	if h.CgroupParent != testPodCgroup || len(h.Links) != 0 {
+		t.Fatalf("expected sidecar in pod cgroup without links, got %s and %v", h.CgroupParent, h.Links)
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/docker/docker/registry"
+)
+
+// ComposeProfile enables the API surface docker-compose needs: networks, labels, healthchecks
+const ComposeProfile = "compose"
+
+// Policy holds the configurable restrictions applied by the proxy, on top of the hard-coded white-list of supported
+// APIs and parameters. A zero value Policy doesn't add any restriction.
+type Policy struct {
+	// Registries one is allowed to search images from, using registry host name (`docker.io` for Docker Hub).
+	// Empty means no restriction.
+	SearchRegistries []string `json:"searchRegistries,omitempty"`
+
+	// What to do with tenant resources when session ends
+	Teardown TeardownPolicy `json:"teardown,omitempty"`
+
+	// Reject container creation with parameters Lancelot doesn't support, rather than just warn they are ignored
+	Strict bool `json:"strict,omitempty"`
+
+	// Additional field rules for generic endpoints, indexed by endpoint method and path, like `POST /volumes/create`
+	Rules map[string][]FieldRule `json:"rules,omitempty"`
+
+	// Limits on resources tenant can own
+	Quotas Quotas `json:"quotas,omitempty"`
+
+	// Host paths sidecars can bind mount. Others can only be bound from a volume mounted by caller
+	BindMounts []BindMountRule `json:"bindMounts,omitempty"`
+
+	// Caps on tmpfs and shm sizes, and log driver forced on containers
+	Storage StoragePolicy `json:"storage,omitempty"`
+
+	// Labels and environment injected on created resources, and environment clients can't set
+	Inject InjectPolicy `json:"inject,omitempty"`
+
+	// Repositories tenants can push images to. Empty means no restriction
+	Push []PushRule `json:"push,omitempty"`
+
+	// Tags tenants can set on images, by build or `docker tag`. Empty means no restriction
+	Tags []TagRule `json:"tags,omitempty"`
+
+	// Limits on files uploaded to containers
+	Archive ArchivePolicy `json:"archive,omitempty"`
+
+	// Compatibility profile, enabling additional API surface for a client. Only "compose" is supported
+	Profile string `json:"profile,omitempty"`
+}
+
+// LoadPolicy reads Policy from a JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policy := &Policy{}
+	if err := json.NewDecoder(f).Decode(policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Teardown.validate(); err != nil {
+		return nil, err
+	}
+	if err := policy.Quotas.validate(); err != nil {
+		return nil, err
+	}
+	if err := policy.Storage.validate(); err != nil {
+		return nil, err
+	}
+	if err := policy.Inject.validate(); err != nil {
+		return nil, err
+	}
+	for _, r := range policy.Push {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range policy.Tags {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := policy.Archive.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateBindMounts(policy.BindMounts); err != nil {
+		return nil, err
+	}
+	if err := validateRules(policy.Rules); err != nil {
+		return nil, err
+	}
+	if policy.Profile != "" && policy.Profile != ComposeProfile {
+		return nil, errors.New("Unsupported profile: " + policy.Profile)
+	}
+	return policy, nil
+}
+
+/**
+ Check a search term (which might be prefixed by a registry host) can be sent to registry
+ */
+func (p *Policy) allowSearch(term string) bool {
+	if len(p.SearchRegistries) == 0 {
+		return true
+	}
+	index, err := registry.ParseSearchIndexInfo(term)
+	if err != nil {
+		return false
+	}
+	return contains(p.SearchRegistries, index.Name)
+}
@@ -8,19 +8,33 @@ import (
 	"fmt"
 	"golang.org/x/net/context"
 	"sync"
-	"time"
+	"os"
 	"strings"
-	"github.com/pkg/errors"
+	"time"
 )
 
 type Proxy struct {
 	client client.APIClient
-	cgroup string  // Our current cgroup, we will share with any container we run
+	cgroup Cgroup  // Our current cgroup, we will share with any container we run
 	hostname string
+	tenant string // Prefix for container and volume names, so tenants get a private namespace
+	root string   // First sidecar container, started from command line
+	expectRoot bool
+	pod *Pod // Kubernetes pod sandbox we run in, if any
+	self string // Container Lancelot runs in, which volumes can be shared with sidecars. Not the pod sandbox in a pod
+	limits CgroupLimits // CPU and memory limits shared by sidecars, reported by info
+	hostRoot string // Where host filesystem is mounted, to resolve symlinks in bind mounts
+	idleTimeout time.Duration // How long attached streams can go without traffic
+	minAPIVersion string
 	containers	[]string
 	execs           []string
 	images		[]string
+	built		[]string // IDs of images built by tenant, which can be pushed
+	tags		[]string // Tags set by tenant through build or tag, removed on teardown
 	volumes		[]string
+	networks	[]string
+	policy		Policy
+	audit		*AuditTrail
 	mux		sync.Mutex // Mutex to prevent concurrent access to containers|execs|images
 }
 
@@ -42,6 +56,15 @@ func (p *Proxy) addVolume(id string) {
 	}
 }
 
+func (p *Proxy) addNetwork(id string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !contains(p.networks, id) {
+		fmt.Printf("recording allowed access to network %s\n", id)
+		p.networks = append(p.networks, id)
+	}
+}
+
 func (p *Proxy) addExec(id string) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
@@ -72,6 +95,9 @@ func (p *Proxy) ownsContainer(id string) (string, error) {
 			return id, nil
 		}
 	}
+	if name := strings.TrimPrefix(p.daemonName(id), "/"); contains(p.containers, name) {
+		return name, nil
+	}
 
 	candidates := []string{}
 	for _,c := range p.containers {
@@ -84,19 +110,46 @@ func (p *Proxy) ownsContainer(id string) (string, error) {
 	}
 
 	if len(candidates) > 1 {
-		return id, errors.New("Multiple IDs found with provided prefix: "+id);
+		return id, badRequest("Multiple IDs found with provided prefix: %s", id)
 	}
 
-	return id, errors.New("No such container: "+id);
+	return id, notFound("No such container: %s", id)
 }
 
 func (p *Proxy) ownsVolume(id string) (string, error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	if ok := contains(p.volumes, id); !ok {
-		return id, errors.New("No such volume: "+id);
+	if contains(p.volumes, id) {
+		return id, nil
 	}
-	return id, nil
+	if name := p.daemonName(id); contains(p.volumes, name) {
+		return name, nil
+	}
+	return id, notFound("No such volume: %s", id)
+}
+
+/**
+ Resolve a network by ID, name or unique ID prefix, as known by daemon. Networks are recorded by ID and name.
+ */
+func (p *Proxy) ownsNetwork(id string) (string, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if contains(p.networks, id) {
+		return id, nil
+	}
+	if name := p.daemonName(id); contains(p.networks, name) {
+		return name, nil
+	}
+	candidates := []string{}
+	for _, n := range p.networks {
+		if len(n) == 64 && strings.HasPrefix(n, id) {
+			candidates = append(candidates, n)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return id, notFound("network %s not found", id)
 }
 
 func (p *Proxy) ownsExec(id string) bool {
@@ -126,43 +179,52 @@ func contains(list []string, value string) bool {
 }
 
 func (p *Proxy) RegisterRoutes(r *mux.Router) {
-	r.Path("/_ping").Methods("GET").HandlerFunc(p.ping)
-	r.Path("/v{version:[0-9.]+}/version").Methods("GET").HandlerFunc(p.version)
-	r.Path("/v{version:[0-9.]+}/info").Methods("GET").HandlerFunc(p.info)
-	r.Path("/v{version:[0-9.]+}/events").Methods("GET").HandlerFunc(p.events)
-
-	r.Path("/v{version:[0-9.]+}/images/json").Methods("GET").HandlerFunc(p.imagesList)
-	r.Path("/v{version:[0-9.]+}/images/create").Methods("POST").HandlerFunc(p.imagesCreate)
-	r.Path("/v{version:[0-9.]+}/images/{name:.*}/json").Methods("GET").HandlerFunc(p.imageInspect)
-	r.Path("/v{version:[0-9.]+}/images/{name:.*}/tag").Methods("POST").HandlerFunc(p.imageTag)
- 	r.Path("/v{version:[0-9.]+}/images/{name:.*}/push").Methods("POST").HandlerFunc(p.imagePush)
-
-	r.Path("/v{version:[0-9.]+}/containers/json").Methods("GET").HandlerFunc(p.containerList)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/json").Methods("GET").HandlerFunc(p.containerInspect)
-	r.Path("/v{version:[0-9.]+}/containers/create").Methods("POST").HandlerFunc(p.containerCreate)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/start").Methods("POST").HandlerFunc(p.containerStart)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/resize").Methods("POST").HandlerFunc(p.containerResize)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/attach").Methods("POST").HandlerFunc(p.containerAttach)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/stop").Methods("POST").HandlerFunc(p.containerStop)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/kill").Methods("POST").HandlerFunc(p.containerKill)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/exec").Methods("POST").HandlerFunc(p.containerExecCreate)
-	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/start").Methods("POST").HandlerFunc(p.containerExecStart)
-	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/resize").Methods("POST").HandlerFunc(p.containerExecResize)
-	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/json").Methods("GET").HandlerFunc(p.execInspect)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}").Methods("DELETE").HandlerFunc(p.containerDelete)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/archive").Methods("GET").HandlerFunc(p.containerArchiveGet)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/archive").Methods("PUT").HandlerFunc(p.containerArchivePut)
-	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/logs").Methods("GET").HandlerFunc(p.containerLogs)
-
-	r.Path("/v{version:[0-9.]+}/volumes").Methods("GET").HandlerFunc(p.volumeList)
-	r.Path("/v{version:[0-9.]+}/volumes/create").Methods("POST").HandlerFunc(p.volumeCreate)
-	r.Path("/v{version:[0-9.]+}/volumes/{name:*}").Methods("DELETE").HandlerFunc(p.volumeDelete)
-
-	r.Path("/v{version:[0-9.]+}/build").Methods("POST").HandlerFunc(p.build)
+	r.Path("/_ping").Methods("GET").HandlerFunc(p.versioned(p.ping))
+	r.Path("/v{version:[0-9.]+}/version").Methods("GET").HandlerFunc(p.versioned(p.version))
+	r.Path("/v{version:[0-9.]+}/info").Methods("GET").HandlerFunc(p.versioned(p.info))
+	r.Path("/v{version:[0-9.]+}/events").Methods("GET").HandlerFunc(p.versioned(p.events))
+
+	r.Path("/v{version:[0-9.]+}/images/json").Methods("GET").HandlerFunc(p.versioned(p.imagesList))
+	r.Path("/v{version:[0-9.]+}/images/search").Methods("GET").HandlerFunc(p.versioned(p.imagesSearch))
+	r.Path("/v{version:[0-9.]+}/images/create").Methods("POST").HandlerFunc(p.versioned(p.imagesCreate))
+	r.Path("/v{version:[0-9.]+}/images/{name:.*}/history").Methods("GET").HandlerFunc(p.versioned(p.imageHistory))
+	r.Path("/v{version:[0-9.]+}/images/{name:.*}/json").Methods("GET").HandlerFunc(p.versioned(p.imageInspect))
+	r.Path("/v{version:[0-9.]+}/images/{name:.*}/tag").Methods("POST").HandlerFunc(p.versioned(p.imageTag))
+ 	r.Path("/v{version:[0-9.]+}/images/{name:.*}/push").Methods("POST").HandlerFunc(p.versioned(p.imagePush))
+
+	r.Path("/v{version:[0-9.]+}/containers/json").Methods("GET").HandlerFunc(p.versioned(p.containerList))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/json").Methods("GET").HandlerFunc(p.versioned(p.containerInspect))
+	r.Path("/v{version:[0-9.]+}/containers/create").Methods("POST").HandlerFunc(p.versioned(p.containerCreate))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/start").Methods("POST").HandlerFunc(p.versioned(p.containerStart))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/resize").Methods("POST").HandlerFunc(p.versioned(p.containerResize))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/attach").Methods("POST").HandlerFunc(p.versioned(p.containerAttach))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/stop").Methods("POST").HandlerFunc(p.versioned(p.containerStop))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/kill").Methods("POST").HandlerFunc(p.versioned(p.containerKill))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/exec").Methods("POST").HandlerFunc(p.versioned(p.containerExecCreate))
+	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/start").Methods("POST").HandlerFunc(p.versioned(p.containerExecStart))
+	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/resize").Methods("POST").HandlerFunc(p.versioned(p.containerExecResize))
+	r.Path("/v{version:[0-9.]+}/exec/{execId:.*}/json").Methods("GET").HandlerFunc(p.versioned(p.execInspect))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}").Methods("DELETE").HandlerFunc(p.versioned(p.containerDelete))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/archive").Methods("HEAD").HandlerFunc(p.versioned(p.containerArchiveHead))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/archive").Methods("GET").HandlerFunc(p.versioned(p.containerArchiveGet))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/archive").Methods("PUT").HandlerFunc(p.versioned(p.containerArchivePut))
+	r.Path("/v{version:[0-9.]+}/containers/{name:.*}/logs").Methods("GET").HandlerFunc(p.versioned(p.containerLogs))
+
+	r.Path("/v{version:[0-9.]+}/volumes").Methods("GET").HandlerFunc(p.versioned(p.volumeList))
+	r.Path("/v{version:[0-9.]+}/volumes/{name:.*}").Methods("DELETE").HandlerFunc(p.versioned(p.volumeDelete))
+
+	r.Path("/v{version:[0-9.]+}/build").Methods("POST").HandlerFunc(p.versioned(p.build))
+
+	r.Path("/v{version:[0-9.]+}/distribution/{name:.*}/json").Methods("GET").HandlerFunc(p.versioned(p.distributionInspect))
+
+	for _, e := range endpoints {
+		r.Path("/v{version:[0-9.]+}" + e.Path).Methods(e.Method).HandlerFunc(p.versioned(p.forward(e)))
+	}
 }
 
 func (p *Proxy) SetClient(c client.APIClient) {
 	p.client = c
+	p.client.NegotiateAPIVersion(context.Background())
 
 	i, err := p.client.Info(context.Background())
 	if err != nil {
@@ -178,14 +240,42 @@ func (p *Proxy) SetClient(c client.APIClient) {
 	if versions.LessThan(ping.APIVersion, api.DefaultVersion) {
 		fmt.Printf("[[WARNING]] target docker daemon exposes API %s but proxy was designed for API version %s\n", ping.APIVersion, api.DefaultVersion)
 	}
+	fmt.Printf("serving API versions %s to %s\n", p.MinAPIVersion(), p.MaxAPIVersion())
+}
+
+func (p *Proxy) SetPolicy(policy *Policy) {
+	p.policy = *policy
+}
+
+func (p *Proxy) SetCgroup(cgroup *Cgroup) {
+	fmt.Printf("running in cgroup %s (%s layout, %s driver)\n", cgroup.Parent, cgroup.Layout, cgroup.Driver)
+	if cgroup.Driver == SystemdDriver && cgroup.Layout != "kubernetes-systemd" {
+		fmt.Printf("[[WARNING]] systemd can't nest sidecars under a container scope, they will run in slice %s\n", cgroup.Parent)
+	}
+	p.cgroup = *cgroup
+	p.self = cgroup.ContainerID
 }
 
-func (p *Proxy) SetCgroup(cgroup string) {
-	p.cgroup = cgroup
+/**
+ CPU and memory limits sidecars share, reported by info instead of host resources
+ */
+func (p *Proxy) SetCgroupLimits(limits CgroupLimits) {
+	fmt.Printf("sidecars limited to %g CPUs and %d bytes of memory (0 means no limit)\n", limits.CPUs, limits.Memory)
+	p.limits = limits
 }
 
+/**
+ Parent cgroup for sidecar containers
+ */
 func (p *Proxy) GetCgroup() string {
-	return "/docker/" + p.cgroup
+	return p.cgroup.Parent
+}
+
+/**
+ Host filesystem, as mounted in Lancelot container, so allowlisted bind mounts can be checked for symlinks
+ */
+func (p *Proxy) SetHostRoot(root string) {
+	p.hostRoot = root
 }
 
 func (p *Proxy) SetHostname(host string) {
@@ -198,18 +288,9 @@ func (p *Proxy) GetHostname() string {
 
 func (p *Proxy) Stop() {
 	fmt.Println("Shutting down...");
-	timeout := 10 * time.Second
-
-	var wg sync.WaitGroup
-	wg.Add(len(p.containers))
-	for _, c := range p.containers {
-		go func(id string) {
-			defer wg.Done()
-			fmt.Printf("Stopping container %s\n", id)
-			p.client.ContainerStop(context.Background(), id, &timeout)
-		}(c)
-	}
-	wg.Wait()
+	report := p.Teardown()
+	fmt.Println("Teardown report:")
+	report.Print(os.Stdout)
 }
 
 
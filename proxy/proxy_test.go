@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cloudbees/lancelot/proxy/fake"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// testProxy runs a proxy in front of a fake daemon, served over HTTP as the docker CLI would reach it
+type testProxy struct {
+	t      *testing.T
+	daemon *fake.Daemon
+	proxy  *Proxy
+	server *httptest.Server
+}
+
+// cgroup sidecars are placed in by test proxy
+const testCgroup = "/lancelot/test"
+
+var versioned = regexp.MustCompile(`^/v[0-9.]+/`)
+
+func newTestProxy(t *testing.T, policy Policy) *testProxy {
+	daemon := fake.NewDaemon()
+	if err := daemon.Publish("busybox", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Proxy{}
+	p.SetClient(daemon)
+	p.SetPolicy(&policy)
+	p.SetCgroup(CgroupOverride(testCgroup))
+	p.SetAuditTrail(NewAuditTrail(ioutil.Discard))
+
+	r := mux.NewRouter()
+	p.RegisterRoutes(r)
+	return &testProxy{
+		t:      t,
+		daemon: daemon,
+		proxy:  p,
+		server: httptest.NewServer(r),
+	}
+}
+
+func (tp *testProxy) Close() {
+	tp.server.Close()
+}
+
+/**
+ Send a request to proxy, body being JSON encoded. Path gets the current API version prefix unless it has one.
+ Headers are given as name, value pairs.
+ */
+func (tp *testProxy) do(method, path string, body interface{}, headers ...string) *http.Response {
+	tp.t.Helper()
+	if !versioned.MatchString(path) && !strings.HasPrefix(path, "/_") {
+		path = "/v" + fake.APIVersion + path
+	}
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			tp.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, tp.server.URL+path, bytes.NewReader(b))
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	return res
+}
+
+/**
+ Check response status, and return response body
+ */
+func (tp *testProxy) expect(res *http.Response, status int) []byte {
+	tp.t.Helper()
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	if res.StatusCode != status {
+		tp.t.Fatalf("%s %s: expected status %d, got %d: %s", res.Request.Method, res.Request.URL.Path, status, res.StatusCode, b)
+	}
+	return b
+}
+
+/**
+ Check response is a docker error with status and message containing text
+ */
+func (tp *testProxy) expectError(res *http.Response, status int, text string) {
+	tp.t.Helper()
+	e := types.ErrorResponse{}
+	if err := json.Unmarshal(tp.expect(res, status), &e); err != nil {
+		tp.t.Fatalf("error response is not JSON: %s", err)
+	}
+	if !strings.Contains(e.Message, text) {
+		tp.t.Fatalf("expected error message to contain %q, got %q", text, e.Message)
+	}
+}
+
+func (tp *testProxy) decode(res *http.Response, status int, v interface{}) {
+	tp.t.Helper()
+	if err := json.Unmarshal(tp.expect(res, status), v); err != nil {
+		tp.t.Fatal(err)
+	}
+}
+
+/**
+ Create a container through proxy, returning its ID
+ */
+func (tp *testProxy) create(config interface{}, query string) string {
+	tp.t.Helper()
+	body := container.ContainerCreateCreatedBody{}
+	tp.decode(tp.do("POST", "/containers/create"+query, config), http.StatusCreated, &body)
+	return body.ID
+}
+
+/**
+ Create and start a container through proxy, returning its ID
+ */
+func (tp *testProxy) run(image string) string {
+	tp.t.Helper()
+	id := tp.create(map[string]interface{}{"Image": image, "Cmd": []string{"cat"}}, "")
+	tp.expect(tp.do("POST", "/containers/"+id+"/start", nil), http.StatusNoContent)
+	return id
+}
+
+/**
+ Create a running container on daemon, as another tenant would do
+ */
+func (tp *testProxy) foreignContainer() string {
+	tp.t.Helper()
+	ctx := context.Background()
+	if _, err := tp.daemon.ImagePull(ctx, "busybox", types.ImagePullOptions{}); err != nil {
+		tp.t.Fatal(err)
+	}
+	body, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: "busybox"}, nil, nil, "")
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	if err := tp.daemon.ContainerStart(ctx, body.ID, types.ContainerStartOptions{}); err != nil {
+		tp.t.Fatal(err)
+	}
+	return body.ID
+}
+
+func TestForeignContainersAreHidden(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	foreign := tp.foreignContainer()
+	mine := tp.run("busybox")
+
+	tp.expectError(tp.do("GET", "/containers/"+foreign+"/json", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("GET", "/containers/"+foreign[:12]+"/json", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("POST", "/containers/"+foreign+"/stop", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("POST", "/containers/"+foreign+"/kill", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("DELETE", "/containers/"+foreign+"?force=1", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("POST", "/containers/"+foreign+"/pause", nil), http.StatusNotFound, "No such container")
+	tp.expectError(tp.do("POST", "/containers/"+foreign+"/exec", map[string]interface{}{"Cmd": []string{"sh"}}), http.StatusNotFound, "No such container")
+
+	json := types.ContainerJSON{}
+	tp.decode(tp.do("GET", "/containers/"+mine[:12]+"/json", nil), http.StatusOK, &json)
+	if json.ID != mine {
+		t.Fatalf("expected container %s, got %s", mine, json.ID)
+	}
+
+	list := []types.Container{}
+	tp.decode(tp.do("GET", "/containers/json?all=1", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != mine {
+		t.Fatalf("expected only own container to be listed, got %v", list)
+	}
+
+	// foreign container is still there
+	if _, err := tp.daemon.ContainerInspect(context.Background(), foreign); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForeignExecsAreHidden(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	foreign := tp.foreignContainer()
+	exec, err := tp.daemon.ContainerExecCreate(context.Background(), foreign, types.ExecConfig{Cmd: []string{"sh"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.expectError(tp.do("GET", "/exec/"+exec.ID+"/json", nil), http.StatusNotFound, "No such exec instance")
+	tp.expectError(tp.do("POST", "/exec/"+exec.ID+"/resize?h=10&w=10", nil), http.StatusNotFound, "No such exec instance")
+	tp.expectError(tp.do("POST", "/exec/"+exec.ID+"/start", map[string]interface{}{}), http.StatusNotFound, "No such exec instance")
+}
+
+func TestForeignVolumesAreHidden(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	if _, err := tp.daemon.VolumeCreate(context.Background(), volumetypes.VolumesCreateBody{Name: "foreign"}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "mine"}), http.StatusCreated)
+
+	list := volumetypes.VolumesListOKBody{}
+	tp.decode(tp.do("GET", "/volumes", nil), http.StatusOK, &list)
+	if len(list.Volumes) != 1 || list.Volumes[0].Name != "mine" {
+		t.Fatalf("expected only own volume to be listed, got %v", list.Volumes)
+	}
+
+	tp.expectError(tp.do("DELETE", "/volumes/foreign", nil), http.StatusNotFound, "No such volume")
+	tp.expect(tp.do("DELETE", "/volumes/mine", nil), http.StatusNoContent)
+}
+
+func TestUnsupportedAPIVersion(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expectError(tp.do("GET", "/v1.99/info", nil), http.StatusBadRequest, "is too new")
+
+	// no JSON errors before API 1.24
+	b := tp.expect(tp.do("GET", "/v1.5/info", nil), http.StatusBadRequest)
+	if !strings.Contains(string(b), "is too old") {
+		t.Fatalf("unexpected error %s", b)
+	}
+}
+
+func TestInfoReportsTenantView(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.proxy.SetHostname("lancelot")
+	tp.proxy.SetCgroupLimits(CgroupLimits{CPUs: 1.5, Memory: 512 * 1024 * 1024})
+
+	tp.foreignContainer()
+	tp.run("busybox")
+	tp.create(map[string]interface{}{"Image": "busybox"}, "?name=stopped")
+
+	info := types.Info{}
+	tp.decode(tp.do("GET", "/info", nil), http.StatusOK, &info)
+	if info.Containers != 2 || info.ContainersRunning != 1 || info.ContainersStopped != 1 || info.Images != 1 {
+		t.Errorf("expected tenant resources to be counted, got %d containers (%d running, %d stopped), %d images",
+			info.Containers, info.ContainersRunning, info.ContainersStopped, info.Images)
+	}
+	if info.NCPU != 2 || info.MemTotal != 512*1024*1024 {
+		t.Errorf("expected cgroup limits, got %d CPUs and %d bytes", info.NCPU, info.MemTotal)
+	}
+	if info.ID != "" || info.Name != "lancelot" || info.DockerRootDir != "" {
+		t.Errorf("host identifying fields should be redacted, got %q %q %q", info.ID, info.Name, info.DockerRootDir)
+	}
+	if info.OperatingSystem != "Fake Linux" || info.Driver != "overlay2" {
+		t.Errorf("expected platform to be reported, got %q %q", info.OperatingSystem, info.Driver)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PushRule allows tenants to push images to repositories under some prefixes
+type PushRule struct {
+	// Tenant names or patterns like `team-a-*`. Rule applies to all tenants when empty
+	Tenants []string `json:"tenants,omitempty"`
+	// Repository prefixes, registry included, like `registry.example.com/team-a/` or `docker.io/teama/`
+	Repositories []string `json:"repositories"`
+}
+
+func (r PushRule) validate() error {
+	if err := validatePatterns(r.Tenants); err != nil {
+		return err
+	}
+	if len(r.Repositories) == 0 {
+		return errors.New("Push rule doesn't allow any repository")
+	}
+	return nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("Invalid pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+func (r PushRule) appliesTo(tenant string) bool {
+	return matchesTenant(r.Tenants, tenant)
+}
+
+/**
+ Tell if tenant matches one of the names or patterns of a rule, a rule without any applying to all tenants
+ */
+func matchesTenant(patterns []string, tenant string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tenant); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ Tell if a repository, as a fully qualified name like `docker.io/library/ubuntu`, is under a prefix. Prefix matches
+ on path components, so `docker.io/team` doesn't allow `docker.io/teamb/app`
+ */
+func underRepository(repository, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return repository == prefix || strings.HasPrefix(repository, prefix+"/")
+}
+
+func (p *Proxy) addBuiltImage(id string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !contains(p.built, id) {
+		p.built = append(p.built, id)
+	}
+}
+
+func (p *Proxy) isBuiltImage(id string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return contains(p.built, id)
+}
+
+/**
+ Check push policy allows tenant to push an image, as `repository[:tag]`. Pushed images must have been built by
+ tenant, so one can't publish a shared base image re-tagged under its own repository.
+ */
+func (p *Proxy) checkPush(ctx context.Context, name string) error {
+	if len(p.policy.Push) == 0 {
+		return nil
+	}
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return badRequest("%s", err.Error())
+	}
+
+	allowed := false
+	for _, rule := range p.policy.Push {
+		if !rule.appliesTo(p.GetTenant()) {
+			continue
+		}
+		for _, prefix := range rule.Repositories {
+			allowed = allowed || underRepository(named.Name(), prefix)
+		}
+	}
+	if !allowed {
+		return denied("push to %s is not authorized", named.Name())
+	}
+
+	// without a tag, all tags of repository are pushed
+	images, err := p.client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return err
+	}
+	_, tagged := named.(reference.Tagged)
+	for _, i := range images {
+		for _, t := range i.RepoTags {
+			ref, err := reference.ParseNormalizedNamed(t)
+			if err != nil || ref.Name() != named.Name() {
+				continue
+			}
+			if tagged && ref.String() != named.String() {
+				continue
+			}
+			if !p.isBuiltImage(i.ID) {
+				return denied("%s was not built by tenant, only images built through Lancelot can be pushed", reference.FamiliarString(ref))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestPushPolicy(t *testing.T) {
+	tp := newTestProxy(t, Policy{Push: []PushRule{
+		{Tenants: []string{"team-a-*"}, Repositories: []string{"registry.example.com/team-a/", "docker.io/teama"}},
+		{Repositories: []string{"registry.example.com/shared"}},
+	}})
+	defer tp.Close()
+	tp.proxy.SetTenant("team-a-42")
+
+	tp.expect(tp.do("POST", "/build?t=registry.example.com/team-a/app:1", nil), http.StatusOK)
+	tp.expect(tp.do("POST", "/images/registry.example.com/team-a/app:1/tag?repo=teama/app&tag=1", nil), http.StatusCreated)
+	tp.expect(tp.do("POST", "/images/registry.example.com/team-a/app:1/tag?repo=registry.example.com/team-b/app&tag=1", nil), http.StatusCreated)
+	tp.expect(tp.do("POST", "/images/registry.example.com/team-a/app:1/tag?repo=registry.example.com/shared/app&tag=1", nil), http.StatusCreated)
+
+	for _, image := range []string{"registry.example.com/team-a/app", "teama/app", "registry.example.com/shared/app"} {
+		res := tp.do("POST", "/images/"+image+"/push?tag=1", nil)
+		tp.expect(res, http.StatusOK)
+	}
+
+	tp.expectError(tp.do("POST", "/images/registry.example.com/team-b/app/push?tag=1", nil), http.StatusForbidden, "push to registry.example.com/team-b/app is not authorized")
+
+	// base image re-tagged under an allowed repository
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	tp.expect(tp.do("POST", "/images/busybox:latest/tag?repo=teama/busybox&tag=latest", nil), http.StatusCreated)
+	tp.expectError(tp.do("POST", "/images/teama/busybox/push?tag=latest", nil), http.StatusForbidden, "teama/busybox:latest was not built by tenant")
+}
+
+func TestPushRulesApplyToTenants(t *testing.T) {
+	tp := newTestProxy(t, Policy{Push: []PushRule{{Tenants: []string{"team-a-*"}, Repositories: []string{"docker.io/teama"}}}})
+	defer tp.Close()
+	tp.proxy.SetTenant("team-b-1")
+
+	res := tp.do("POST", "/build?t=teama/app", nil)
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	tp.expectError(tp.do("POST", "/images/teama/app/push?tag=latest", nil), http.StatusForbidden, "push to docker.io/teama/app is not authorized")
+}
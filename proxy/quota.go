@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-units"
+	"golang.org/x/net/context"
+)
+
+// quotas, as named in errors
+const (
+	ContainersQuota        = "containers"
+	RunningContainersQuota = "running containers"
+	VolumesQuota           = "volumes"
+	ImagesQuota            = "images"
+	ImagesSizeQuota        = "images size"
+	VolumesSizeQuota       = "volumes size"
+)
+
+// Quotas limit resources a tenant can own. Sizes are set as human readable sizes, like `10GB`. Zero or empty means no
+// limit.
+type Quotas struct {
+	Containers        int64  `json:"containers,omitempty"`
+	RunningContainers int64  `json:"runningContainers,omitempty"`
+	Volumes           int64  `json:"volumes,omitempty"`
+	Images            int64  `json:"images,omitempty"`
+	ImagesSize        string `json:"imagesSize,omitempty"`
+	VolumesSize       string `json:"volumesSize,omitempty"`
+}
+
+func (q Quotas) validate() error {
+	for _, s := range []string{q.ImagesSize, q.VolumesSize} {
+		if s == "" {
+			continue
+		}
+		if _, err := units.FromHumanSize(s); err != nil {
+			return fmt.Errorf("Invalid quota size: %s", s)
+		}
+	}
+	return nil
+}
+
+/**
+ Limits set by quotas, indexed by quota name
+ */
+func (q Quotas) limits() map[string]int64 {
+	limits := map[string]int64{
+		ContainersQuota:        q.Containers,
+		RunningContainersQuota: q.RunningContainers,
+		VolumesQuota:           q.Volumes,
+		ImagesQuota:            q.Images,
+	}
+	if q.ImagesSize != "" {
+		limits[ImagesSizeQuota], _ = units.FromHumanSize(q.ImagesSize)
+	}
+	if q.VolumesSize != "" {
+		limits[VolumesSizeQuota], _ = units.FromHumanSize(q.VolumesSize)
+	}
+	return limits
+}
+
+func isSizeQuota(quota string) bool {
+	return quota == ImagesSizeQuota || quota == VolumesSizeQuota
+}
+
+/**
+ Images owned by tenant, with references normalized as daemon reports them. Must be called with lock held.
+ */
+func (p *Proxy) ownedImages() []string {
+	images := []string{}
+	for _, i := range p.images {
+		// images are recorded as requested by client, like `busybox`
+		images = append(images, familiarImage(i))
+	}
+	return images
+}
+
+/**
+ Normalize an image reference, like `busybox` into `busybox:latest`
+ */
+func familiarImage(ref string) string {
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		return reference.FamiliarString(reference.TagNameOnly(named))
+	}
+	return ref
+}
+
+/**
+ Tell whether an image is in owned images, as returned by ownedImages, by ID or one of its tags
+ */
+func isOwnedImage(owned []string, i types.ImageSummary) bool {
+	if contains(owned, i.ID) {
+		return true
+	}
+	for _, t := range i.RepoTags {
+		if contains(owned, t) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ Tell whether using image would add one to the images owned by tenant
+ */
+func (p *Proxy) isNewImage(ref string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return !contains(p.ownedImages(), familiarImage(ref))
+}
+
+/**
+ Compute resources owned by tenant, from ownership registry and daemon disk usage
+ */
+func (p *Proxy) usage(ctx context.Context) (map[string]int64, error) {
+	du, err := p.client.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mux.Lock()
+	containers := append([]string{}, p.containers...)
+	volumes := append([]string{}, p.volumes...)
+	images := p.ownedImages()
+	p.mux.Unlock()
+
+	usage := map[string]int64{}
+	for _, c := range du.Containers {
+		if !contains(containers, c.ID) {
+			continue
+		}
+		usage[ContainersQuota]++
+		if c.State == "running" || c.State == "paused" {
+			usage[RunningContainersQuota]++
+		}
+	}
+	for _, v := range du.Volumes {
+		if !contains(volumes, v.Name) {
+			continue
+		}
+		usage[VolumesQuota]++
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			usage[VolumesSizeQuota] += v.UsageData.Size
+		}
+	}
+	for _, i := range du.Images {
+		if isOwnedImage(images, *i) {
+			usage[ImagesQuota]++
+			// layers shared by images are accounted for each of them
+			usage[ImagesSizeQuota] += i.Size
+		}
+	}
+	return usage, nil
+}
+
+/**
+ Check tenant can create one more resource without exceeding quotas. Disk usage is only computed when one of the
+ quotas is set, as it might be costly on daemon.
+ */
+func (p *Proxy) checkQuota(ctx context.Context, quotas ...string) error {
+	limits := p.policy.Quotas.limits()
+	set := false
+	for _, q := range quotas {
+		set = set || limits[q] > 0
+	}
+	if !set {
+		return nil
+	}
+
+	usage, err := p.usage(ctx)
+	if err != nil {
+		return err
+	}
+	for _, q := range quotas {
+		max := limits[q]
+		if max <= 0 || usage[q] < max {
+			continue
+		}
+		if isSizeQuota(q) {
+			return denied("Quota exceeded: %s is %s, limit is %s", q, units.HumanSize(float64(usage[q])), units.HumanSize(float64(max)))
+		}
+		return denied("Quota exceeded: %d %s, limit is %d", usage[q], q, max)
+	}
+	return nil
+}
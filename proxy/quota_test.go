@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestContainerQuotas(t *testing.T) {
+	tp := newTestProxy(t, Policy{Quotas: Quotas{Containers: 2, RunningContainers: 1}})
+	defer tp.Close()
+
+	// containers of other tenants are not accounted
+	tp.foreignContainer()
+
+	running := tp.run("busybox")
+	stopped := tp.create(map[string]interface{}{"Image": "busybox"}, "")
+	tp.expectError(tp.do("POST", "/containers/"+stopped+"/start", nil), http.StatusForbidden, "Quota exceeded: 1 running containers, limit is 1")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "busybox"}), http.StatusForbidden, "Quota exceeded: 2 containers, limit is 2")
+
+	tp.expect(tp.do("POST", "/containers/"+running+"/stop", nil), http.StatusNoContent)
+	tp.expect(tp.do("POST", "/containers/"+stopped+"/start", nil), http.StatusNoContent)
+	tp.expect(tp.do("DELETE", "/containers/"+running, nil), http.StatusNoContent)
+	tp.create(map[string]interface{}{"Image": "busybox"}, "")
+}
+
+func TestVolumeQuotas(t *testing.T) {
+	tp := newTestProxy(t, Policy{Quotas: Quotas{Volumes: 2, VolumesSize: "1MB"}})
+	defer tp.Close()
+
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "data"}), http.StatusCreated)
+	if err := tp.daemon.SetVolumeSize("data", 2000000); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "more"}), http.StatusForbidden, "Quota exceeded: volumes size is 2MB, limit is 1MB")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "busybox", "Volumes": map[string]interface{}{"/data": struct{}{}}}), http.StatusForbidden, "volumes size")
+
+	tp.daemon.SetVolumeSize("data", 0)
+	tp.create(map[string]interface{}{"Image": "busybox", "Volumes": map[string]interface{}{"/data": struct{}{}}}, "")
+	tp.expectError(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "more"}), http.StatusForbidden, "Quota exceeded: 2 volumes, limit is 2")
+}
+
+func TestImageQuotas(t *testing.T) {
+	tp := newTestProxy(t, Policy{Quotas: Quotas{Images: 2}})
+	defer tp.Close()
+
+	for _, i := range []string{"alpine", "debian"} {
+		if err := tp.daemon.Publish(i, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tp.create(map[string]interface{}{"Image": "busybox"}, "")
+	tp.expect(tp.do("POST", "/images/create?fromImage=alpine&tag=latest", nil), http.StatusOK)
+	tp.expectError(tp.do("POST", "/images/create?fromImage=debian&tag=latest", nil), http.StatusForbidden, "Quota exceeded: 2 images, limit is 2")
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{"Image": "debian"}), http.StatusForbidden, "Quota exceeded: 2 images, limit is 2")
+
+	// image already owned doesn't need more room
+	tp.create(map[string]interface{}{"Image": "alpine"}, "")
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+}
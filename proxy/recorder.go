@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// bodies are recorded up to this size, so a build context or log stream doesn't blow up recording
+const maxRecordedBody = 64 * 1024
+
+// headers carrying credentials, which are never recorded
+var redactedHeaders = []string{"X-Registry-Auth", "X-Registry-Config", "Authorization"}
+
+const (
+	Allowed = "allowed"
+	Denied  = "denied"
+	Failed  = "failed"
+)
+
+// RecordedMessage is a request or response as recorded, with body up to maxRecordedBody
+type RecordedMessage struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// "base64" when body isn't text
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	// body size seen, when it has been truncated
+	Truncated int64 `json:"truncated,omitempty"`
+	// connection was hijacked to stream raw data
+	Hijacked bool `json:"hijacked,omitempty"`
+}
+
+// UpstreamCall is a call to daemon made while handling a request
+type UpstreamCall struct {
+	Call     string `json:"call"`
+	Resource string `json:"resource,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Exchange is a request handled by proxy, with the response sent, decision taken and upstream calls made to handle it
+type Exchange struct {
+	Time     time.Time       `json:"time"`
+	Request  RecordedMessage `json:"request"`
+	Response RecordedMessage `json:"response"`
+	Decision string          `json:"decision"`
+	Message  string          `json:"message,omitempty"`
+	Upstream []UpstreamCall  `json:"upstream,omitempty"`
+	mux      sync.Mutex
+}
+
+// Recorder writes every exchange handled by proxy as JSON lines
+type Recorder struct {
+	out io.Writer
+	mux sync.Mutex
+}
+
+func NewRecorder(out io.Writer) *Recorder {
+	return &Recorder{out: out}
+}
+
+type exchangeKey struct{}
+
+/**
+ Exchange being recorded for a request, if any
+ */
+func exchange(ctx context.Context) *Exchange {
+	e, _ := ctx.Value(exchangeKey{}).(*Exchange)
+	return e
+}
+
+func (e *Exchange) call(call, resource string, err error) {
+	c := UpstreamCall{Call: call, Resource: resource}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.Upstream = append(e.Upstream, c)
+}
+
+/**
+ Record body of a message, as text when possible
+ */
+func (m *RecordedMessage) setBody(b []byte, size int64) {
+	if size > int64(len(b)) {
+		m.Truncated = size
+	}
+	if utf8.Valid(b) {
+		m.Body = string(b)
+	} else {
+		m.Body = base64.StdEncoding.EncodeToString(b)
+		m.BodyEncoding = "base64"
+	}
+}
+
+/**
+ Decoded body of a recorded message
+ */
+func (m *RecordedMessage) RawBody() []byte {
+	if m.BodyEncoding == "base64" {
+		b, _ := base64.StdEncoding.DecodeString(m.Body)
+		return b
+	}
+	return []byte(m.Body)
+}
+
+func recordedHeader(h http.Header) http.Header {
+	c := http.Header{}
+	for k, v := range h {
+		c[k] = v
+	}
+	for _, r := range redactedHeaders {
+		if c.Get(r) != "" {
+			c.Set(r, "<redacted>")
+		}
+	}
+	return c
+}
+
+// capped captures the first maxRecordedBody bytes written to or read through it, and counts all of them
+type capped struct {
+	buf  bytes.Buffer
+	size int64
+}
+
+func (c *capped) Write(b []byte) (int, error) {
+	c.size += int64(len(b))
+	if room := maxRecordedBody - c.buf.Len(); room > 0 {
+		if len(b) > room {
+			c.buf.Write(b[:room])
+		} else {
+			c.buf.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// recordingWriter captures response sent to client
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	body     capped
+	hijacked bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recordingWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+/**
+ Wrap proxy handler so all exchanges get recorded
+ */
+func (rec *Recorder) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &Exchange{
+			Time: time.Now().UTC(),
+			Request: RecordedMessage{
+				Method: r.Method,
+				URL:    r.URL.RequestURI(),
+				Header: recordedHeader(r.Header),
+			},
+		}
+
+		body := &capped{}
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, body), r.Body}
+		}
+		rw := &recordingWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, e)))
+
+		// handler might not have read the whole body, but we want it to be replayed. Reading one more byte tells
+		// whether it has to be truncated.
+		if r.Body != nil && body.size <= maxRecordedBody {
+			io.Copy(ioutil.Discard, io.LimitReader(r.Body, maxRecordedBody+1-body.size))
+		}
+		e.Request.setBody(body.buf.Bytes(), body.size)
+
+		e.Response.Hijacked = rw.hijacked
+		e.Response.Status = rw.status
+		if rw.hijacked {
+			e.Response.Status = http.StatusOK
+			if _, upgrade := r.Header["Upgrade"]; upgrade {
+				e.Response.Status = http.StatusSwitchingProtocols
+			}
+		}
+		e.Response.Header = recordedHeader(w.Header())
+		e.Response.setBody(rw.body.buf.Bytes(), rw.body.size)
+		e.Decision, e.Message = decision(e.Response)
+		rec.Record(e)
+	})
+}
+
+/**
+ Tell how proxy handled a request, from the response it sent
+ */
+func decision(res RecordedMessage) (string, string) {
+	if res.Status < http.StatusBadRequest {
+		return Allowed, ""
+	}
+	message := strings.TrimSpace(res.Body)
+	e := struct{ Message string }{}
+	if err := json.Unmarshal([]byte(res.Body), &e); err == nil && e.Message != "" {
+		message = e.Message
+	}
+	if res.Header.Get(DeniedHeader) != "" {
+		return Denied, message
+	}
+	return Failed, message
+}
+
+func (rec *Recorder) Record(e *Exchange) {
+	e.mux.Lock()
+	b, err := json.Marshal(e)
+	e.mux.Unlock()
+	if err != nil {
+		return
+	}
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	rec.out.Write(append(b, '\n'))
+}
+
+/**
+ Read a recording, as written by Recorder
+ */
+func ReadRecording(in io.Reader) ([]*Exchange, error) {
+	exchanges := []*Exchange{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		e := &Exchange{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges, scanner.Err()
+}
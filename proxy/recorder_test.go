@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRecordingIsReplayed(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	out := &bytes.Buffer{}
+	rec := NewRecorder(out)
+	tp.proxy.SetClient(rec.Client(tp.daemon))
+	tp.server.Config.Handler = rec.Handler(tp.server.Config.Handler)
+
+	id := tp.run("busybox")
+	tp.expect(tp.do("GET", "/containers/"+id[:12]+"/json", nil, "X-Registry-Auth", "secret"), http.StatusOK)
+	tp.expect(tp.do("GET", "/images/search?term=registry.example.com/busybox", nil), http.StatusOK)
+	tp.expect(tp.do("DELETE", "/containers/"+id+"?force=1", nil), http.StatusNoContent)
+
+	if strings.Contains(out.String(), "secret") {
+		t.Fatalf("credentials should not be recorded: %s", out)
+	}
+	exchanges, err := ReadRecording(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 5 {
+		t.Fatalf("expected 5 exchanges recorded, got %d", len(exchanges))
+	}
+	calls := []string{}
+	for _, c := range exchanges[0].Upstream {
+		calls = append(calls, c.Call)
+	}
+	if !contains(calls, "ContainerCreate") {
+		t.Fatalf("expected upstream calls to be recorded, got %v", calls)
+	}
+
+	// replay on a fresh daemon, with search now restricted
+	replay := newTestProxy(t, Policy{SearchRegistries: []string{"docker.io"}})
+	defer replay.Close()
+	r := mux.NewRouter()
+	replay.proxy.RegisterRoutes(r)
+
+	results, err := Replay(r, exchanges, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		search := strings.Contains(res.Recorded.Request.URL, "/images/search")
+		if res.Changed() != search {
+			t.Errorf("unexpected replay of request %d: %s", i, res)
+		}
+	}
+	if d := results[3].Replayed.Decision; d != Denied {
+		t.Errorf("expected search to be denied on replay, got %s", d)
+	}
+}
+
+func TestRecordedBodiesAreCapped(t *testing.T) {
+	out := &bytes.Buffer{}
+	rec := NewRecorder(out)
+	h := rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 2*maxRecordedBody))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/build", bytes.NewReader(make([]byte, 3*maxRecordedBody))))
+
+	exchanges, err := ReadRecording(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := exchanges[0]
+	// handler didn't read request body, which is drained only as much as needed to record it
+	if e.Request.Truncated <= maxRecordedBody || len(e.Request.RawBody()) != maxRecordedBody {
+		t.Errorf("request body should be capped, got %d bytes out of %d", len(e.Request.RawBody()), e.Request.Truncated)
+	}
+	if e.Response.Truncated != 2*maxRecordedBody || len(e.Response.RawBody()) != maxRecordedBody {
+		t.Errorf("response body should be capped, got %d bytes out of %d", len(e.Response.RawBody()), e.Response.Truncated)
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DefaultIdleTimeout is how long an attached stream can go without any traffic before it is closed
+const DefaultIdleTimeout = time.Hour
+
+// how long client can keep sending once stream output ended, before its connection is closed
+const drainTimeout = time.Second
+
+var errIdle = errors.New("stream idle timeout")
+
+// closeWriter is a connection which can be half-closed, like TCP and unix sockets
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// stream relays a hijacked connection between docker client and daemon, as raw connections so io.Copy can splice
+// them on Linux
+type stream struct {
+	client   net.Conn
+	buffered *bufio.Reader // read ahead from client by HTTP server
+	upstream types.HijackedResponse
+	idle     time.Duration // zero meaning no timeout
+	last     int64         // last traffic, in unix nanoseconds
+	stopped  int32
+	in       int64 // bytes sent by client
+	out      int64 // bytes sent to client
+}
+
+/**
+ Set how long an attached stream can go without traffic, in either direction, before it is closed. Zero disables it.
+ */
+func (p *Proxy) SetIdleTimeout(timeout time.Duration) {
+	p.idleTimeout = timeout
+}
+
+func (s *stream) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *stream) idleFor() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&s.last))
+}
+
+/**
+ Copy from a connection till it ends, buffered data first. With an idle timeout, reads are given a deadline of half of
+ it, so traffic is accounted for while splicing, and copy stops once stream had no traffic for the whole timeout.
+ */
+func (s *stream) pump(dst io.Writer, src net.Conn, buffered *bufio.Reader) (int64, error) {
+	var n int64
+	if buffered != nil && buffered.Buffered() > 0 {
+		b, err := io.CopyN(dst, buffered, int64(buffered.Buffered()))
+		n += b
+		s.touch()
+		if err != nil {
+			return n, err
+		}
+	}
+	for {
+		if s.idle > 0 {
+			src.SetReadDeadline(time.Now().Add(s.idle / 2))
+		}
+		b, err := io.Copy(dst, src)
+		n += b
+		if b > 0 {
+			s.touch()
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			if atomic.LoadInt32(&s.stopped) == 1 {
+				return n, nil
+			}
+			if s.idle > 0 && s.idleFor() < s.idle {
+				continue
+			}
+			return n, errIdle
+		}
+		return n, err
+	}
+}
+
+/**
+ Stop copy from client, which blocks in a read otherwise
+ */
+func (s *stream) stopInput() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.client.SetReadDeadline(time.Now())
+}
+
+/**
+ Relay a hijacked stream till daemon ends it, client disconnects, context is canceled or stream is idle. Each side
+ is half-closed when the other one is done sending, so a process reading stdin gets EOF and all of its output still
+ reaches client.
+ */
+func (p *Proxy) relay(ctx context.Context, resource string, client net.Conn, buffered *bufio.Reader, upstream types.HijackedResponse) {
+	s := &stream{client: client, buffered: buffered, upstream: upstream, idle: p.idleTimeout}
+	s.touch()
+
+	input := make(chan error, 1)
+	go func() {
+		n, err := s.pump(upstream.Conn, client, buffered)
+		s.in = n
+		if err == nil {
+			upstream.CloseWrite()
+		}
+		input <- err
+	}()
+	output := make(chan error, 1)
+	go func() {
+		n, err := s.pump(client, upstream.Conn, upstream.Reader)
+		s.out = n
+		output <- err
+	}()
+
+	var end error
+	for ended := false; !ended; {
+		select {
+		case err := <-input:
+			input = nil
+			if err != nil {
+				end, ended = err, true
+			}
+		case end = <-output:
+			output, ended = nil, true
+		case <-ctx.Done():
+			end, ended = ctx.Err(), true
+		}
+	}
+
+	upstream.Close()
+	if output == nil && end == nil {
+		// all output is written, client gets EOF but can still be sending
+		if c, ok := client.(closeWriter); ok {
+			c.CloseWrite()
+		}
+		if input != nil {
+			s.stopInput()
+			<-input
+		}
+		// closing a connection with unread data resets it, client could then lose output it didn't read yet
+		client.SetReadDeadline(time.Now().Add(drainTimeout))
+		io.Copy(ioutil.Discard, client)
+		client.Close()
+	} else {
+		client.Close()
+		if input != nil {
+			<-input
+		}
+		if output != nil {
+			<-output
+		}
+	}
+
+	reason := "completed"
+	switch {
+	case end == errIdle:
+		reason = "idle"
+	case end == context.Canceled || end == context.DeadlineExceeded:
+		reason = "canceled"
+	case end != nil:
+		reason = end.Error()
+	}
+	fmt.Printf("End of stream %s (%s), %d bytes in, %d bytes out\n", resource, reason, s.in, s.out)
+	p.record("stream", resource, map[string]interface{}{"stdin": s.in, "stdout": s.out, "end": reason})
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+// auditBuffer keeps audit trail of a test, written by handlers while test reads it
+type auditBuffer struct {
+	buf bytes.Buffer
+	mux sync.Mutex
+}
+
+func (a *auditBuffer) Write(b []byte) (int, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.buf.Write(b)
+}
+
+func (a *auditBuffer) String() string {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.buf.String()
+}
+
+/**
+ Stream events recorded in audit trail
+ */
+func streamEvents(t *testing.T, audit *auditBuffer) []AuditEvent {
+	t.Helper()
+	events := []AuditEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		e := AuditEvent{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Action == "stream" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestExecWithPipedStdin(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	audit := &auditBuffer{}
+	tp.proxy.SetAuditTrail(NewAuditTrail(audit))
+
+	id := tp.run("busybox")
+	exec := types.IDResponse{}
+	tp.decode(tp.do("POST", "/containers/"+id+"/exec", map[string]interface{}{"Cmd": []string{"cat"}, "AttachStdin": true, "AttachStdout": true}), http.StatusCreated, &exec)
+	conn, r := tp.hijack("/exec/"+exec.ID+"/start?stdin=1&stdout=1", `{"Detach":false,"Tty":true}`)
+	defer conn.Close()
+
+	// as `cat big.log | docker exec -i`, process only gets EOF when client half-closes
+	input := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	go func() {
+		conn.Write(input)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Fatalf("expected %d bytes echoed, got %d", len(input), len(output))
+	}
+
+	conn.Close()
+	for i := 0; i < 50 && len(streamEvents(t, audit)) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	events := streamEvents(t, audit)
+	if len(events) != 1 || events[0].Resource != exec.ID || events[0].Details["end"] != "completed" ||
+		events[0].Details["stdin"] != float64(len(input)) || events[0].Details["stdout"] != float64(len(input)) {
+		t.Fatalf("unexpected audit events %+v", events)
+	}
+}
+
+func TestIdleStreamIsClosed(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.proxy.SetIdleTimeout(200 * time.Millisecond)
+
+	id := tp.run("busybox")
+	conn, r := tp.hijack("/containers/"+id+"/attach?stream=1&stdin=1&stdout=1", "")
+	defer conn.Close()
+
+	// traffic keeps stream open past timeout
+	for i := 0; i < 5; i++ {
+		conn.Write([]byte("."))
+		if b, err := r.ReadByte(); err != nil || b != '.' {
+			t.Fatalf("expected stdin to be echoed, got %q %v", b, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("expected idle stream to be closed, took %s", time.Since(start))
+	}
+}
+
+func TestStreamCanceled(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	audit := &auditBuffer{}
+	tp.proxy.SetAuditTrail(NewAuditTrail(audit))
+
+	id := tp.run("busybox")
+	h, err := tp.daemon.ContainerAttach(context.Background(), id, types.ContainerAttachOptions{Stream: true, Stdin: true, Stdout: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, peer := net.Pipe()
+	defer peer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tp.proxy.relay(ctx, id, client, nil, h)
+		close(done)
+	}()
+
+	peer.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := peer.Read(b); err != nil || string(b) != "ping" {
+		t.Fatalf("expected stdin to be echoed, got %q %v", b, err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected stream to end once canceled")
+	}
+	if _, err := peer.Read(b); err == nil {
+		t.Fatal("expected client connection to be closed")
+	}
+	if events := streamEvents(t, audit); len(events) != 1 || events[0].Details["end"] != "canceled" {
+		t.Fatalf("unexpected audit events %+v", events)
+	}
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/pkg/errors"
+)
+
+// ReplayResult compares how a recorded request has been handled, and how it is when replayed
+type ReplayResult struct {
+	Recorded *Exchange
+	Replayed *Exchange
+}
+
+/**
+ Decision taken on request changed on replay
+ */
+func (r ReplayResult) Changed() bool {
+	return r.Recorded.Decision != r.Replayed.Decision || r.Recorded.Response.Status != r.Replayed.Response.Status
+}
+
+func (r ReplayResult) String() string {
+	req := r.Recorded.Request.Method + " " + r.Recorded.Request.URL
+	if !r.Changed() {
+		return fmt.Sprintf("  %d %-7s %s", r.Replayed.Response.Status, r.Replayed.Decision, req)
+	}
+	s := fmt.Sprintf("! %s\n    recorded: %d %s %s\n    replayed: %d %s %s", req,
+		r.Recorded.Response.Status, r.Recorded.Decision, r.Recorded.Message,
+		r.Replayed.Response.Status, r.Replayed.Decision, r.Replayed.Message)
+	return strings.TrimRight(s, " ")
+}
+
+/**
+ Images referenced by recorded requests, which have to be available from registry for a replay to succeed
+ */
+func RecordedImages(exchanges []*Exchange) []string {
+	images := []string{}
+	add := func(i string) {
+		if i != "" && !contains(images, i) {
+			images = append(images, i)
+		}
+	}
+	for _, e := range exchanges {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			continue
+		}
+		if i := u.Query().Get("fromImage"); i != "" {
+			if t := u.Query().Get("tag"); t != "" {
+				i = i + ":" + t
+			}
+			add(i)
+		}
+		body := struct{ Image string }{}
+		if json.Unmarshal(e.Request.RawBody(), &body) == nil {
+			add(body.Image)
+		}
+	}
+	return images
+}
+
+// replayer sends recorded requests to a proxy, translating IDs of resources created in recording to the ones
+// created on replay
+type replayer struct {
+	addr    string
+	timeout time.Duration
+	ids     map[string]string
+}
+
+/**
+ Replay recorded requests against a proxy handler, in order, served on a loopback port. Streamed responses are read
+ until timeout.
+ */
+func Replay(h http.Handler, exchanges []*Exchange, timeout time.Duration) ([]ReplayResult, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't serve replayed requests")
+	}
+	server := &http.Server{Handler: h}
+	go server.Serve(l)
+	defer server.Close()
+
+	r := &replayer{
+		addr:    l.Addr().String(),
+		timeout: timeout,
+		ids:     map[string]string{},
+	}
+
+	results := []ReplayResult{}
+	for _, e := range exchanges {
+		replayed := r.replay(e)
+		r.learn(e, replayed)
+		results = append(results, ReplayResult{Recorded: e, Replayed: replayed})
+	}
+	return results, nil
+}
+
+/**
+ Rewrite IDs from recording into the ones of replay
+ */
+func (r *replayer) translate(s string) string {
+	for recorded, replayed := range r.ids {
+		s = strings.Replace(s, recorded, replayed, -1)
+	}
+	for recorded, replayed := range r.ids {
+		if len(recorded) == 64 && len(replayed) == 64 {
+			s = strings.Replace(s, stringid.TruncateID(recorded), stringid.TruncateID(replayed), -1)
+		}
+	}
+	return s
+}
+
+/**
+ Learn IDs of resources created by both recorded and replayed requests
+ */
+func (r *replayer) learn(recorded, replayed *Exchange) {
+	if recorded.Decision != Allowed || replayed.Decision != Allowed {
+		return
+	}
+	type created struct {
+		ID   string `json:"Id"`
+		Name string
+	}
+	before, after := created{}, created{}
+	if json.Unmarshal([]byte(recorded.Response.Body), &before) != nil || json.Unmarshal([]byte(replayed.Response.Body), &after) != nil {
+		return
+	}
+	if before.ID != "" && after.ID != "" && before.ID != after.ID {
+		r.ids[before.ID] = after.ID
+	}
+	// volumes get a generated name when none is set
+	if strings.HasSuffix(recorded.Request.URL, "/volumes/create") && before.Name != after.Name && before.Name != "" && after.Name != "" {
+		r.ids[before.Name] = after.Name
+	}
+}
+
+func (r *replayer) replay(e *Exchange) *Exchange {
+	replayed := &Exchange{
+		Time: time.Now().UTC(),
+		Request: RecordedMessage{
+			Method: e.Request.Method,
+			URL:    r.translate(e.Request.URL),
+			Header: e.Request.Header,
+		},
+	}
+	body := []byte(r.translate(string(e.Request.RawBody())))
+	replayed.Request.setBody(body, int64(len(body)))
+
+	var res *http.Response
+	var err error
+	if e.Response.Hijacked {
+		res, err = r.hijack(replayed.Request, body)
+	} else {
+		res, err = r.send(replayed.Request, body)
+	}
+	if err != nil {
+		replayed.Response.Status = http.StatusBadGateway
+		replayed.Decision, replayed.Message = Failed, err.Error()
+		return replayed
+	}
+	defer res.Body.Close()
+
+	b := &capped{}
+	io.Copy(b, res.Body) // streamed responses end with timeout
+	replayed.Response.Status = res.StatusCode
+	replayed.Response.Header = recordedHeader(res.Header)
+	replayed.Response.Hijacked = e.Response.Hijacked
+	replayed.Response.setBody(b.buf.Bytes(), b.size)
+	replayed.Decision, replayed.Message = decision(replayed.Response)
+	return replayed
+}
+
+func (r *replayer) request(m RecordedMessage, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(m.Method, "http://"+r.addr+m.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m.Header {
+		req.Header[k] = v
+	}
+	// credentials are not recorded
+	for _, h := range redactedHeaders {
+		req.Header.Del(h)
+	}
+	return req, nil
+}
+
+func (r *replayer) send(m RecordedMessage, body []byte) (*http.Response, error) {
+	req, err := r.request(m, body)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: r.timeout}
+	return client.Do(req)
+}
+
+/**
+ Send a request which hijacks connection. Only response head is read, as there's no stream to replay.
+ */
+func (r *replayer) hijack(m RecordedMessage, body []byte) (*http.Response, error) {
+	req, err := r.request(m, body)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{}
+	if res.StatusCode >= http.StatusBadRequest {
+		b, _ = ioutil.ReadAll(io.LimitReader(res.Body, maxRecordedBody))
+	}
+	// don't wait for a raw stream
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return res, nil
+}
@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// FieldAction tells how a rule applies to a request body field
+type FieldAction string
+
+const (
+	// Allow lets field be forwarded to daemon. As soon as an endpoint declares allowed fields, all others are dropped
+	Allow FieldAction = "allow"
+	// Deny rejects request if field is set
+	Deny FieldAction = "deny"
+	// Force sets field to rule value, whatever the client asked for
+	Force FieldAction = "force"
+)
+
+// FieldRule applies to a field of request body, set as a dotted path to struct field, like `Resources.Memory`
+type FieldRule struct {
+	Field  string      `json:"field"`
+	Action FieldAction `json:"action"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+/**
+ Check rule applies to a field of a typed request body, with an action we support. A rule on an unknown field would
+ be ignored, so a typo in a Deny rule would let requests through.
+ */
+func (r FieldRule) validate(body reflect.Type) error {
+	t, ok := fieldType(body, r.Field)
+	if !ok {
+		return errors.Errorf("unknown field %s", r.Field)
+	}
+	switch r.Action {
+	case Allow, Deny:
+	case Force:
+		b, err := json.Marshal(r.Value)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, reflect.New(t).Interface()); err != nil {
+			return errors.Wrapf(err, "invalid value for %s", r.Field)
+		}
+	default:
+		return errors.Errorf("unsupported rule action %s for %s", r.Action, r.Field)
+	}
+	return nil
+}
+
+/**
+ Check policy rules, indexed by endpoint key, apply to generic endpoints with a body
+ */
+func validateRules(rules map[string][]FieldRule) error {
+	for key, list := range rules {
+		var endpoint *Endpoint
+		for i, e := range endpoints {
+			if e.Key() == key {
+				endpoint = &endpoints[i]
+			}
+		}
+		if endpoint == nil || endpoint.Body == nil {
+			return errors.Errorf("Invalid rules: %s is not an endpoint with a body rules apply to", key)
+		}
+		for _, rule := range list {
+			if err := rule.validate(reflect.TypeOf(endpoint.Body())); err != nil {
+				return errors.Wrapf(err, "Invalid rules for %s", key)
+			}
+		}
+	}
+	return nil
+}
+
+// Endpoint declares an API endpoint forwarded by the generic layer: request body is decoded into the typed API struct,
+// field rules are applied, then request is forwarded with the upstream client.
+type Endpoint struct {
+	Method string
+	// Path, without the API version prefix
+	Path string
+	// Path variables which have to reference a resource owned by tenant, with resource kind
+	Owns map[string]string
+	// Typed request body, nil for endpoints without a body
+	Body  func() interface{}
+	Rules []FieldRule
+	// Forward request to daemon, body being nil or the filtered typed struct. Returns response to be sent as JSON, if any
+	Forward func(p *Proxy, r *http.Request, vars map[string]string, body interface{}) (interface{}, error)
+	// Status on success
+	Status int
+	// Compatibility profile endpoint is only served with, if any
+	Profile string
+}
+
+// Key identifies endpoint in policy rules
+func (e Endpoint) Key() string {
+	return e.Method + " " + e.Path
+}
+
+// resource kinds for Endpoint.Owns
+const (
+	ContainerResource = "container"
+	VolumeResource    = "volume"
+	ExecResource      = "exec"
+	ImageResource     = "image"
+	NetworkResource   = "network"
+)
+
+/**
+ Resolve a path variable to an owned resource
+ */
+func (p *Proxy) owns(kind, id string) (string, error) {
+	switch kind {
+	case ContainerResource:
+		return p.ownsContainer(id)
+	case VolumeResource:
+		return p.ownsVolume(id)
+	case ExecResource:
+		if !p.ownsExec(id) {
+			return id, notFound("No such exec instance: %s", id)
+		}
+	case ImageResource:
+		if !p.ownsImage(id) {
+			return id, notFound("No such image: %s", id)
+		}
+	case NetworkResource:
+		return p.ownsNetwork(id)
+	}
+	return id, nil
+}
+
+/**
+ Generic handler for a declared endpoint
+ */
+func (p *Proxy) forward(e Endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e.Profile != "" && e.Profile != p.policy.Profile {
+			writeError(w, r, notFound("%s is only supported by Lancelot with %s profile", e.Key(), e.Profile))
+			return
+		}
+		if err := httputils.ParseForm(r); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		vars := map[string]string{}
+		for k, v := range mux.Vars(r) {
+			vars[k] = v
+		}
+		for v, kind := range e.Owns {
+			id, err := p.owns(kind, vars[v])
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			vars[v] = id
+		}
+
+		var body interface{}
+		if e.Body != nil {
+			if err := httputils.CheckForJSON(r); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			requested := e.Body()
+			if err := json.NewDecoder(r.Body).Decode(requested); err != nil {
+				writeError(w, r, badRequest("%s", err.Error()))
+				return
+			}
+			rules := append(append([]FieldRule{}, e.Rules...), p.policy.Rules[e.Key()]...)
+			filtered, err := applyRules(requested, rules)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			body = filtered
+		}
+
+		res, err := e.Forward(p, r, vars, body)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		status := e.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if res == nil {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSON(w, r, status, res)
+	}
+}
+
+/**
+ Apply field rules on a typed request body. When some fields are explicitly allowed, a new struct is created with only
+ those fields copied, so anything we don't know about is dropped.
+ */
+func applyRules(requested interface{}, rules []FieldRule) (interface{}, error) {
+	src := reflect.ValueOf(requested).Elem()
+
+	allowed := []string{}
+	for _, rule := range rules {
+		switch rule.Action {
+		case Deny:
+			if f, ok := field(src, rule.Field, false); ok && !isZero(f) {
+				return nil, denied("%s is not authorized", rule.Field)
+			}
+		case Allow:
+			allowed = append(allowed, rule.Field)
+		case Force:
+		default:
+			return nil, errors.Errorf("unsupported rule action %s for %s", rule.Action, rule.Field)
+		}
+	}
+
+	forwarded := requested
+	if len(allowed) > 0 {
+		forwarded = reflect.New(src.Type()).Interface()
+		dst := reflect.ValueOf(forwarded).Elem()
+		for _, a := range allowed {
+			if f, ok := field(src, a, false); ok {
+				d, ok := field(dst, a, true)
+				if !ok {
+					return nil, errors.Errorf("unknown field %s", a)
+				}
+				d.Set(f)
+			}
+		}
+	}
+
+	dst := reflect.ValueOf(forwarded).Elem()
+	for _, rule := range rules {
+		if rule.Action != Force {
+			continue
+		}
+		d, ok := field(dst, rule.Field, true)
+		if !ok {
+			return nil, errors.Errorf("unknown field %s", rule.Field)
+		}
+		// rule value might come from JSON policy, so let JSON convert it to the field type
+		b, err := json.Marshal(rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		d.Set(reflect.Zero(d.Type()))
+		if err := json.Unmarshal(b, d.Addr().Interface()); err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %s", rule.Field)
+		}
+	}
+	return forwarded, nil
+}
+
+/**
+ Lookup a struct field by dotted path. When create is set, nil pointers on path are allocated.
+ */
+func field(v reflect.Value, path string, create bool) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !create {
+					return v, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return v, false
+		}
+		v = v.FieldByName(name)
+		if !v.IsValid() {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+/**
+ Lookup the type of a struct field by dotted path
+ */
+func fieldType(t reflect.Type, path string) (reflect.Type, bool) {
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return t, false
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return t, false
+		}
+		t = f.Type
+	}
+	return t, true
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
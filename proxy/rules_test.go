@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEndpointRulesAreValid(t *testing.T) {
+	for _, e := range endpoints {
+		if err := validateRules(map[string][]FieldRule{e.Key(): e.Rules}); len(e.Rules) > 0 && err != nil {
+			t.Errorf("invalid rules for %s: %s", e.Key(), err)
+		}
+	}
+}
+
+func TestPolicyRulesAreValidated(t *testing.T) {
+	for policy, message := range map[string]string{
+		`{"rules": {"POST /volumes/create": [{"field": "Labels", "action": "deny"}]}}`:                                           "",
+		`{"rules": {"POST /containers/{name:.*}/update": [{"field": "Resources.Memory", "action": "force", "value": 1048576}]}}`: "",
+		`{"rules": {"POST /volumes/create": [{"field": "Label", "action": "deny"}]}}`:                                            "unknown field Label",
+		`{"rules": {"POST /containers/{name:.*}/update": [{"field": "Resources.Memroy", "action": "deny"}]}}`:                    "unknown field Resources.Memroy",
+		`{"rules": {"POST /volumes/create": [{"field": "Labels", "action": "drop"}]}}`:                                           "unsupported rule action drop for Labels",
+		`{"rules": {"POST /volumes/create": [{"field": "Name", "action": "force", "value": 42}]}}`:                               "invalid value for Name",
+		`{"rules": {"POST /volume/create": [{"field": "Name", "action": "deny"}]}}`:                                              "POST /volume/create is not an endpoint with a body",
+		`{"rules": {"POST /containers/{name:.*}/pause": [{"field": "Name", "action": "deny"}]}}`:                                 "is not an endpoint with a body",
+	} {
+		f, err := ioutil.TempFile("", "policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(policy)
+		f.Close()
+		_, err = LoadPolicy(f.Name())
+		os.Remove(f.Name())
+		if message == "" && err != nil {
+			t.Errorf("expected policy %s to load, got %s", policy, err)
+		}
+		if message != "" && (err == nil || !strings.Contains(err.Error(), message)) {
+			t.Errorf("expected policy %s to be refused with %q, got %v", policy, message, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// defaults applied when policy doesn't set storage limits
+const (
+	DefaultTmpfsSize = "64m"
+	DefaultShmSize   = "64m"
+	DefaultLogDriver = "json-file"
+)
+
+// DefaultLogOptions rotate logs of json-file and local log drivers
+var DefaultLogOptions = map[string]string{"max-size": "10m", "max-file": "3"}
+
+// tmpfs mount options sidecars can set, besides size and mode
+var tmpfsFlags = []string{"ro", "rw", "exec", "noexec", "suid", "nosuid", "dev", "nodev"}
+
+// StoragePolicy caps host memory and disk a container can consume out of daemon defaults: tmpfs mounts and /dev/shm
+// default to half the host memory, logs are never rotated by default json-file driver
+type StoragePolicy struct {
+	// Maximum size of a tmpfs mount, default size for those which don't set one
+	TmpfsSize string `json:"tmpfsSize,omitempty"`
+	// Maximum size of /dev/shm
+	ShmSize string `json:"shmSize,omitempty"`
+	// Log driver forced on all containers
+	LogDriver string `json:"logDriver,omitempty"`
+	// Log driver options forced on all containers, default rotating json-file and local drivers logs
+	LogOptions map[string]string `json:"logOptions,omitempty"`
+}
+
+func (s StoragePolicy) validate() error {
+	for _, size := range []string{s.TmpfsSize, s.ShmSize} {
+		if size == "" {
+			continue
+		}
+		if _, err := units.RAMInBytes(size); err != nil {
+			return errors.Wrap(err, "Invalid storage policy")
+		}
+	}
+	return nil
+}
+
+func (s StoragePolicy) tmpfsSize() int64 {
+	return sizeOrDefault(s.TmpfsSize, DefaultTmpfsSize)
+}
+
+func (s StoragePolicy) shmSize() int64 {
+	return sizeOrDefault(s.ShmSize, DefaultShmSize)
+}
+
+func sizeOrDefault(size, def string) int64 {
+	if size == "" {
+		size = def
+	}
+	b, _ := units.RAMInBytes(size) // validated on load
+	return b
+}
+
+func (s StoragePolicy) logConfig() container.LogConfig {
+	driver := s.LogDriver
+	if driver == "" {
+		driver = DefaultLogDriver
+	}
+	options := s.LogOptions
+	if options == nil && (driver == "json-file" || driver == "local") {
+		options = DefaultLogOptions
+	}
+	config := map[string]string{}
+	for k, v := range options {
+		config[k] = v
+	}
+	return container.LogConfig{Type: driver, Config: config}
+}
+
+/**
+ Apply storage policy to a container: tmpfs mounts and shm get a capped size, log driver is forced. Tmpfs pages are
+ charged to container memory cgroup, so all together, with tmpfs volumes it mounts, they must fit in tenant memory. Returns warnings for client
+ settings which have been overridden.
+ */
+func (p *Proxy) storageLimits(ctx context.Context, requested, forwarded *container.HostConfig) ([]string, error) {
+	policy := p.policy.Storage
+	warnings := []string{}
+	total := int64(0)
+
+	tmpfs := map[string]string{}
+	for target, options := range requested.Tmpfs {
+		opts, size, err := tmpfsOptions(target, options, policy.tmpfsSize())
+		if err != nil {
+			return nil, err
+		}
+		tmpfs[target] = opts
+		total += size
+	}
+	if len(tmpfs) > 0 {
+		forwarded.Tmpfs = tmpfs
+	}
+
+	for i, m := range forwarded.Mounts {
+		if m.Type != mount.TypeTmpfs {
+			continue
+		}
+		if m.TmpfsOptions == nil {
+			m.TmpfsOptions = &mount.TmpfsOptions{}
+		}
+		if m.TmpfsOptions.SizeBytes > policy.tmpfsSize() {
+			return nil, denied("tmpfs %s size %s exceeds limit %s", m.Target, units.BytesSize(float64(m.TmpfsOptions.SizeBytes)), units.BytesSize(float64(policy.tmpfsSize())))
+		}
+		if m.TmpfsOptions.SizeBytes <= 0 {
+			m.TmpfsOptions.SizeBytes = policy.tmpfsSize()
+		}
+		total += m.TmpfsOptions.SizeBytes
+		forwarded.Mounts[i].TmpfsOptions = m.TmpfsOptions
+	}
+
+	if requested.ShmSize > policy.shmSize() {
+		return nil, denied("ShmSize %s exceeds limit %s", units.BytesSize(float64(requested.ShmSize)), units.BytesSize(float64(policy.shmSize())))
+	}
+	forwarded.ShmSize = requested.ShmSize
+	if forwarded.ShmSize <= 0 {
+		forwarded.ShmSize = policy.shmSize()
+	}
+	total += forwarded.ShmSize
+
+	volumes, err := p.tmpfsVolumesSize(ctx, forwarded)
+	if err != nil {
+		return nil, err
+	}
+	total += volumes
+
+	if p.limits.Memory > 0 && total > p.limits.Memory {
+		return nil, denied("tmpfs and shm sizes add up to %s, more than tenant memory %s", units.BytesSize(float64(total)), units.BytesSize(float64(p.limits.Memory)))
+	}
+
+	forwarded.LogConfig = policy.logConfig()
+	if requested.LogConfig.Type != "" && requested.LogConfig.Type != forwarded.LogConfig.Type {
+		warnings = append(warnings, "HostConfig.LogConfig.Type is forced to "+forwarded.LogConfig.Type+" by Lancelot")
+	}
+	for k, v := range requested.LogConfig.Config {
+		if f, ok := forwarded.LogConfig.Config[k]; ok && f != v {
+			warnings = append(warnings, "HostConfig.LogConfig.Config."+k+" is forced to "+f+" by Lancelot")
+		}
+	}
+	return warnings, nil
+}
+
+/**
+ Check options of a tmpfs mount, as `size=64m,mode=1777,noexec`, and set size if missing. Returns options and size.
+ */
+func tmpfsOptions(target, options string, limit int64) (string, int64, error) {
+	size := int64(0)
+	forwarded := []string{}
+	for _, o := range strings.Split(options, ",") {
+		switch {
+		case o == "":
+			continue
+		case strings.HasPrefix(o, "size="):
+			s, err := units.RAMInBytes(strings.TrimPrefix(o, "size="))
+			if err != nil {
+				return "", 0, badRequest("Invalid tmpfs size for %s: %s", target, err.Error())
+			}
+			if s > limit {
+				return "", 0, denied("tmpfs %s size %s exceeds limit %s", target, units.BytesSize(float64(s)), units.BytesSize(float64(limit)))
+			}
+			size = s
+			continue
+		case strings.HasPrefix(o, "mode="), contains(tmpfsFlags, o):
+		default:
+			return "", 0, denied("tmpfs option %s is not authorized", o)
+		}
+		forwarded = append(forwarded, o)
+	}
+	if size <= 0 {
+		size = limit
+	}
+	// in bytes, as kernel only understands k, m and g suffixes
+	forwarded = append(forwarded, "size="+strconv.FormatInt(size, 10))
+	return strings.Join(forwarded, ","), size, nil
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+/**
+ Host config of a container, as seen by daemon
+ */
+func (tp *testProxy) hostConfig(id string) *container.HostConfig {
+	tp.t.Helper()
+	json, err := tp.daemon.ContainerInspect(context.Background(), id)
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	return json.HostConfig
+}
+
+func TestStorageDefaults(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	body := container.ContainerCreateCreatedBody{}
+	tp.decode(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{
+			"Tmpfs":     map[string]string{"/tmp": "", "/run": "size=1m,noexec,mode=1777"},
+			"LogConfig": map[string]interface{}{"Type": "syslog", "Config": map[string]string{"max-size": "1g"}},
+		},
+	}), http.StatusCreated, &body)
+	if len(body.Warnings) != 2 {
+		t.Fatalf("expected log config overrides to be reported, got %v", body.Warnings)
+	}
+
+	h := tp.hostConfig(body.ID)
+	if h.Tmpfs["/tmp"] != "size=67108864" || h.Tmpfs["/run"] != "noexec,mode=1777,size=1048576" {
+		t.Fatalf("expected tmpfs to be sized, got %v", h.Tmpfs)
+	}
+	if h.ShmSize != 64*1024*1024 {
+		t.Fatalf("expected shm to be capped, got %d", h.ShmSize)
+	}
+	if h.LogConfig.Type != "json-file" || h.LogConfig.Config["max-size"] != "10m" || h.LogConfig.Config["max-file"] != "3" {
+		t.Fatalf("expected rotated json-file logs, got %v", h.LogConfig)
+	}
+}
+
+func TestStorageLimits(t *testing.T) {
+	tp := newTestProxy(t, Policy{Storage: StoragePolicy{TmpfsSize: "100m", ShmSize: "256m", LogDriver: "journald"}})
+	defer tp.Close()
+	tp.proxy.SetCgroupLimits(CgroupLimits{Memory: 300 * 1024 * 1024})
+
+	id := tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{
+			"ShmSize": 128 * 1024 * 1024,
+			"Mounts":  []map[string]interface{}{{"Type": "tmpfs", "Target": "/cache"}},
+		},
+	}, "")
+	h := tp.hostConfig(id)
+	if h.ShmSize != 128*1024*1024 || h.Mounts[0].TmpfsOptions.SizeBytes != 100*1024*1024 {
+		t.Fatalf("expected shm and tmpfs sizes, got %d and %v", h.ShmSize, h.Mounts[0].TmpfsOptions)
+	}
+	if h.LogConfig.Type != "journald" || len(h.LogConfig.Config) != 0 {
+		t.Fatalf("expected journald logs without rotation options, got %v", h.LogConfig)
+	}
+
+	for message, hostConfig := range map[string]map[string]interface{}{
+		"tmpfs /tmp size 1GiB exceeds limit 100MiB": {"Tmpfs": map[string]string{"/tmp": "size=1g"}},
+		"tmpfs /c size 200MiB exceeds limit 100MiB": {"Mounts": []map[string]interface{}{{"Type": "tmpfs", "Target": "/c", "TmpfsOptions": map[string]interface{}{"SizeBytes": 200 * 1024 * 1024}}}},
+		"ShmSize 512MiB exceeds limit 256MiB":        {"ShmSize": 512 * 1024 * 1024},
+		"tmpfs option uid=0 is not authorized":       {"Tmpfs": map[string]string{"/tmp": "uid=0"}},
+		"more than tenant memory 300MiB":             {"ShmSize": 256 * 1024 * 1024, "Tmpfs": map[string]string{"/tmp": "size=50m"}},
+	} {
+		tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+			"Image":      "busybox",
+			"HostConfig": hostConfig,
+		}), http.StatusForbidden, message)
+	}
+}
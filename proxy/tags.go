@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"path"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TagRule allows tenants to tag images, by build or `docker tag`, with references matching some patterns
+type TagRule struct {
+	// Tenant names or patterns like `team-a-*`. Rule applies to all tenants when empty
+	Tenants []string `json:"tenants,omitempty"`
+	// Reference patterns, like `team-a/*` for any tag of team-a repositories or `team-a/app:release-*`
+	Patterns []string `json:"patterns"`
+}
+
+func (r TagRule) validate() error {
+	if err := validatePatterns(r.Tenants); err != nil {
+		return err
+	}
+	if err := validatePatterns(r.Patterns); err != nil {
+		return err
+	}
+	if len(r.Patterns) == 0 {
+		return errors.New("Tag rule doesn't allow any tag")
+	}
+	return nil
+}
+
+/**
+ Tell if a tag matches a pattern, as a familiar reference like `team-a/app:1`. A pattern without tag matches any tag.
+ */
+func matchesTag(pattern string, tag reference.NamedTagged) bool {
+	if ok, _ := path.Match(pattern, reference.FamiliarString(tag)); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, reference.FamiliarName(tag))
+	return ok
+}
+
+/**
+ Check tenant can set a tag on an image, by ID, or on the image being built when empty. Beside tag policy, a tag
+ pointing to an image tenant didn't build can't be moved, so one can't poison `ubuntu:latest` for other tenants.
+ */
+func (p *Proxy) checkTag(ctx context.Context, ref string, image string) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return badRequest("%s", err.Error())
+	}
+	tag, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return badRequest("%s is not a tag", ref)
+	}
+
+	if len(p.policy.Tags) > 0 {
+		allowed := false
+		for _, rule := range p.policy.Tags {
+			if !matchesTenant(rule.Tenants, p.GetTenant()) {
+				continue
+			}
+			for _, pattern := range rule.Patterns {
+				allowed = allowed || matchesTag(pattern, tag)
+			}
+		}
+		if !allowed {
+			return denied("tag %s is not authorized", reference.FamiliarString(tag))
+		}
+	}
+
+	current, _, err := p.client.ImageInspectWithRaw(ctx, reference.FamiliarString(tag))
+	if client.IsErrNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.ID != image && !p.isBuiltImage(current.ID) {
+		return denied("tag %s is set on an image tenant didn't build, it can't be overwritten", reference.FamiliarString(tag))
+	}
+	return nil
+}
+
+/**
+ Normalize a tag to its familiar reference, like `app:latest` for `app`
+ */
+func familiarTag(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+/**
+ Record a tag set by tenant, through build or tag, so teardown can remove it
+ */
+func (p *Proxy) addTag(tag string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if t := familiarTag(tag); !contains(p.tags, t) {
+		p.tags = append(p.tags, t)
+	}
+}
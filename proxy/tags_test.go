@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSharedTagsAreProtected(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	tp.expect(tp.do("POST", "/build?t=app:1", nil), http.StatusOK)
+
+	tp.expectError(tp.do("POST", "/images/app:1/tag?repo=busybox&tag=latest", nil), http.StatusForbidden, "tag busybox:latest is set on an image tenant didn't build")
+	tp.expectError(tp.do("POST", "/build?t=busybox", nil), http.StatusForbidden, "tag busybox:latest is set on an image tenant didn't build")
+
+	// moving a tag tenant built, or tagging an image with its own tag, are fine
+	tp.expect(tp.do("POST", "/build?t=app:2", nil), http.StatusOK)
+	tp.expect(tp.do("POST", "/images/app:2/tag?repo=app&tag=1", nil), http.StatusCreated)
+	tp.expect(tp.do("POST", "/images/busybox:latest/tag?repo=busybox&tag=latest", nil), http.StatusCreated)
+}
+
+func TestTagPolicy(t *testing.T) {
+	tp := newTestProxy(t, Policy{Tags: []TagRule{
+		{Tenants: []string{"team-a-*"}, Patterns: []string{"team-a/*", "registry.example.com/team-a/app:release-*"}},
+	}})
+	defer tp.Close()
+	tp.proxy.SetTenant("team-a-1")
+
+	tp.expect(tp.do("POST", "/build?t=team-a/app", nil), http.StatusOK)
+	tp.expect(tp.do("POST", "/images/team-a/app:latest/tag?repo=registry.example.com/team-a/app&tag=release-1", nil), http.StatusCreated)
+
+	tp.expectError(tp.do("POST", "/build?t=team-b/app", nil), http.StatusForbidden, "tag team-b/app:latest is not authorized")
+	tp.expectError(tp.do("POST", "/images/team-a/app:latest/tag?repo=registry.example.com/team-a/app&tag=snapshot", nil), http.StatusForbidden, "tag registry.example.com/team-a/app:snapshot is not authorized")
+
+	tp.proxy.SetTenant("team-b-1")
+	tp.expectError(tp.do("POST", "/build?t=team-a/app", nil), http.StatusForbidden, "tag team-a/app:latest is not authorized")
+}
@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// RetentionLabel is set on resources created with a "keep for some time" teardown policy, so expired leftovers can be
+// removed by a later Lancelot sweep on the same host.
+const RetentionLabel = "com.cloudbees.lancelot.retention"
+
+const (
+	Remove = "remove"
+	Keep   = "keep"
+)
+
+// TeardownPolicy defines what to do with tenant resources when session ends. Each entry is either "remove", "keep"
+// (default) or a duration like "24h" to keep resource for this time before a sweep removes it.
+type TeardownPolicy struct {
+	Containers string `json:"containers,omitempty"`
+	Volumes    string `json:"volumes,omitempty"`
+	Images     string `json:"images,omitempty"`
+}
+
+func (t TeardownPolicy) validate() error {
+	for _, r := range []string{t.Containers, t.Volumes, t.Images} {
+		if _, err := retention(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ Parse a teardown policy entry, returning retention duration. 0 means remove, -1 means keep forever.
+ */
+func retention(policy string) (time.Duration, error) {
+	switch policy {
+	case Remove:
+		return 0, nil
+	case Keep, "":
+		return -1, nil
+	}
+	d, err := time.ParseDuration(policy)
+	if err != nil || d <= 0 {
+		return 0, errors.New("Invalid teardown policy: " + policy)
+	}
+	return d, nil
+}
+
+/**
+ Labels to set on a new resource so a sweep can honor a retention policy
+ */
+func retentionLabels(policy string, labels map[string]string) map[string]string {
+	if d, _ := retention(policy); d > 0 {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[RetentionLabel] = policy
+	}
+	return labels
+}
+
+// TeardownResult lists resources of some kind handled by a teardown
+type TeardownResult struct {
+	Removed []string `json:"removed,omitempty"`
+	Kept    []string `json:"kept,omitempty"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// TeardownReport tells what has been cleaned up on host
+type TeardownReport struct {
+	Containers TeardownResult `json:"containers"`
+	Volumes    TeardownResult `json:"volumes"`
+	Images     TeardownResult `json:"images"`
+	Networks   TeardownResult `json:"networks"`
+}
+
+func (r *TeardownReport) Print(w io.Writer) {
+	b, _ := json.MarshalIndent(r, "", "  ")
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+func (r *TeardownResult) record(id string, err error) {
+	if err != nil {
+		fmt.Println(err.Error())
+		r.Failed = append(r.Failed, id)
+	} else {
+		r.Removed = append(r.Removed, id)
+	}
+}
+
+/**
+ Stop all tenant containers, then apply teardown policy on tenant resources.
+ */
+func (p *Proxy) Teardown() *TeardownReport {
+	report := &TeardownReport{}
+	timeout := 10 * time.Second
+
+	p.mux.Lock()
+	containers := append([]string{}, p.containers...)
+	volumes := append([]string{}, p.volumes...)
+	images := append([]string{}, p.images...)
+	built := append([]string{}, p.built...)
+	tags := append([]string{}, p.tags...)
+	networks := append([]string{}, p.networks...)
+	p.mux.Unlock()
+
+	// containers are recorded by ID and name, resolve actual IDs
+	ids := []string{}
+	gone := []string{}
+	resolved := map[string]string{}
+	for _, c := range containers {
+		json, err := p.client.ContainerInspect(context.Background(), c)
+		if err != nil {
+			gone = append(gone, c)
+			continue
+		}
+		resolved[c] = json.ID
+		if !contains(ids, json.ID) {
+			ids = append(ids, json.ID)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(ids))
+	for _, c := range ids {
+		go func(id string) {
+			defer wg.Done()
+			fmt.Printf("Stopping container %s\n", id)
+			p.client.ContainerStop(context.Background(), id, &timeout)
+		}(c)
+	}
+	wg.Wait()
+
+	if d, _ := retention(p.policy.Teardown.Containers); d == 0 {
+		for _, id := range ids {
+			err := p.client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{})
+			report.Containers.record(id, err)
+		}
+	} else {
+		report.Containers.Kept = ids
+	}
+
+	// networks are useless once containers are removed. They are recorded by ID and name, IDs are enough to remove them.
+	if d, _ := retention(p.policy.Teardown.Containers); d == 0 {
+		for _, n := range networks {
+			if len(n) == 64 {
+				err := p.client.NetworkRemove(context.Background(), n)
+				report.Networks.record(n, err)
+			}
+		}
+	} else {
+		report.Networks.Kept = networks
+	}
+
+	if d, _ := retention(p.policy.Teardown.Volumes); d == 0 {
+		for _, v := range volumes {
+			err := p.client.VolumeRemove(context.Background(), v, false)
+			report.Volumes.record(v, err)
+		}
+	} else {
+		report.Volumes.Kept = volumes
+	}
+
+	if d, _ := retention(p.policy.Teardown.Images); d == 0 {
+		// only tags set by tenant and images it built are removed, images it pulled or ran can be shared with other
+		// tenants. Tags go first, so an image can then be removed by ID once they were its only ones.
+		for _, t := range tags {
+			_, err := p.client.ImageRemove(context.Background(), t, types.ImageRemoveOptions{
+				PruneChildren: true,
+			})
+			if client.IsErrNotFound(err) {
+				err = nil
+			}
+			report.Images.record(t, err)
+		}
+		for _, id := range built {
+			inspect, _, err := p.client.ImageInspectWithRaw(context.Background(), id)
+			if client.IsErrNotFound(err) {
+				// removed with its last tag
+				report.Images.record(id, nil)
+				continue
+			}
+			if err == nil && len(inspect.RepoTags) > 0 {
+				// tagged by someone else, or same as a pulled image when build didn't add any layer
+				report.Images.Kept = append(report.Images.Kept, id)
+				continue
+			}
+			if err == nil {
+				_, err = p.client.ImageRemove(context.Background(), id, types.ImageRemoveOptions{
+					PruneChildren: true,
+				})
+			}
+			report.Images.record(id, err)
+		}
+		for _, i := range images {
+			if !contains(tags, familiarTag(i)) && !contains(built, i) {
+				report.Images.Kept = append(report.Images.Kept, i)
+			}
+		}
+	} else {
+		report.Images.Kept = images
+	}
+
+	for c, id := range resolved {
+		if contains(report.Containers.Removed, id) {
+			gone = append(gone, c)
+		}
+	}
+
+	p.mux.Lock()
+	p.containers = without(p.containers, gone)
+	p.volumes = without(p.volumes, report.Volumes.Removed)
+	p.images = without(p.images, report.Images.Removed)
+	p.built = without(p.built, report.Images.Removed)
+	p.tags = without(p.tags, report.Images.Removed)
+	if len(report.Networks.Failed) == 0 && report.Networks.Kept == nil {
+		p.networks = []string{}
+	}
+	p.execs = []string{}
+	p.mux.Unlock()
+
+	return report
+}
+
+/**
+ Remove resources labelled with an expired retention, left on host by a previous Lancelot session.
+ */
+func (p *Proxy) Sweep() *TeardownReport {
+	report := &TeardownReport{}
+	now := time.Now()
+	labelled := filters.NewArgs()
+	labelled.Add("label", RetentionLabel)
+
+	containers, err := p.client.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: labelled,
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	for _, c := range containers {
+		d, err := retention(c.Labels[RetentionLabel])
+		if err != nil || d <= 0 {
+			continue
+		}
+		json, err := p.client.ContainerInspect(context.Background(), c.ID)
+		if err != nil || json.State == nil || json.State.Running {
+			continue
+		}
+		if finished, err := time.Parse(time.RFC3339Nano, json.State.FinishedAt); err == nil && finished.Add(d).Before(now) {
+			err := p.client.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{})
+			report.Containers.record(c.ID, err)
+		}
+	}
+
+	volumes, err := p.client.VolumeList(context.Background(), labelled)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	for _, v := range volumes.Volumes {
+		d, err := retention(v.Labels[RetentionLabel])
+		if err != nil || d <= 0 {
+			continue
+		}
+		if created, err := time.Parse(time.RFC3339, v.CreatedAt); err == nil && created.Add(d).Before(now) {
+			err := p.client.VolumeRemove(context.Background(), v.Name, false)
+			report.Volumes.record(v.Name, err)
+		}
+	}
+
+	images, err := p.client.ImageList(context.Background(), types.ImageListOptions{
+		Filters: labelled,
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	for _, i := range images {
+		d, err := retention(i.Labels[RetentionLabel])
+		if err != nil || d <= 0 {
+			continue
+		}
+		if time.Unix(i.Created, 0).Add(d).Before(now) {
+			_, err := p.client.ImageRemove(context.Background(), i.ID, types.ImageRemoveOptions{
+				Force:         true,
+				PruneChildren: true,
+			})
+			report.Images.record(i.ID, err)
+		}
+	}
+
+	return report
+}
+
+/**
+ Filter list, removing all values from the excluded lists
+ */
+func without(list []string, excluded ...[]string) []string {
+	filtered := []string{}
+	for _, v := range list {
+		keep := true
+		for _, e := range excluded {
+			if contains(e, v) {
+				keep = false
+			}
+		}
+		if keep {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
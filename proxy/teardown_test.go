@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"golang.org/x/net/context"
+)
+
+// resources created by tenant for teardown tests
+type tenantResources struct {
+	container string
+	built     string // ID of image built as app:1
+	pulled    string // ID of busybox
+}
+
+func (tp *testProxy) tenantResources() tenantResources {
+	tp.t.Helper()
+	tp.expect(tp.do("POST", "/images/create?fromImage=busybox&tag=latest", nil), http.StatusOK)
+	r := tenantResources{container: tp.run("busybox")}
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "data"}), http.StatusCreated)
+	tp.expect(tp.do("POST", "/build?t=app:1", nil), http.StatusOK)
+	// tenant tag on a pulled image
+	tp.expect(tp.do("POST", "/images/busybox:latest/tag?repo=mine&tag=1", nil), http.StatusCreated)
+
+	for ref, id := range map[string]*string{"app:1": &r.built, "busybox": &r.pulled} {
+		i, _, err := tp.daemon.ImageInspectWithRaw(context.Background(), ref)
+		if err != nil {
+			tp.t.Fatal(err)
+		}
+		*id = i.ID
+	}
+	return r
+}
+
+func sorted(list []string) []string {
+	list = append([]string{}, list...)
+	sort.Strings(list)
+	return list
+}
+
+func (tp *testProxy) expectImages(present bool, refs ...string) {
+	tp.t.Helper()
+	for _, ref := range refs {
+		if _, _, err := tp.daemon.ImageInspectWithRaw(context.Background(), ref); (err == nil) != present {
+			tp.t.Errorf("expected image %s present %v, got %v", ref, present, err)
+		}
+	}
+}
+
+func TestTeardownRemove(t *testing.T) {
+	tp := newTestProxy(t, Policy{Teardown: TeardownPolicy{Containers: Remove, Volumes: Remove, Images: Remove}})
+	defer tp.Close()
+	r := tp.tenantResources()
+
+	report := tp.proxy.Teardown()
+	if !reflect.DeepEqual(report.Containers, TeardownResult{Removed: []string{r.container}}) {
+		t.Errorf("unexpected containers report %+v", report.Containers)
+	}
+	if !reflect.DeepEqual(report.Volumes, TeardownResult{Removed: []string{"data"}}) {
+		t.Errorf("unexpected volumes report %+v", report.Volumes)
+	}
+	// pulled images may be used by other tenants
+	if !reflect.DeepEqual(sorted(report.Images.Removed), sorted([]string{"app:1", "mine:1", r.built})) ||
+		!reflect.DeepEqual(sorted(report.Images.Kept), sorted([]string{"busybox", "busybox:latest", r.pulled})) || len(report.Images.Failed) > 0 {
+		t.Errorf("unexpected images report %+v", report.Images)
+	}
+	tp.expectImages(false, "app:1", "mine:1", r.built)
+	tp.expectImages(true, "busybox:latest", r.pulled)
+	if _, err := tp.daemon.ContainerInspect(context.Background(), r.container); err == nil {
+		t.Error("expected container to be removed")
+	}
+
+	// report is printed as JSON
+	out := &bytes.Buffer{}
+	report.Print(out)
+	printed := &TeardownReport{}
+	if err := json.Unmarshal(out.Bytes(), printed); err != nil || !reflect.DeepEqual(printed, report) {
+		t.Errorf("unexpected printed report %s %v", out, err)
+	}
+}
+
+func TestTeardownKeepsBuiltImageTaggedByOthers(t *testing.T) {
+	tp := newTestProxy(t, Policy{Teardown: TeardownPolicy{Images: Remove}})
+	defer tp.Close()
+	r := tp.tenantResources()
+	if err := tp.daemon.ImageTag(context.Background(), "app:1", "other/app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	report := tp.proxy.Teardown()
+	if !reflect.DeepEqual(sorted(report.Images.Removed), []string{"app:1", "mine:1"}) || !contains(report.Images.Kept, r.built) {
+		t.Errorf("unexpected images report %+v", report.Images)
+	}
+	tp.expectImages(true, "other/app:1")
+}
+
+func TestTeardownKeep(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	r := tp.tenantResources()
+
+	report := tp.proxy.Teardown()
+	if len(report.Containers.Removed)+len(report.Volumes.Removed)+len(report.Images.Removed) > 0 ||
+		!reflect.DeepEqual(report.Containers.Kept, []string{r.container}) || !reflect.DeepEqual(report.Volumes.Kept, []string{"data"}) ||
+		!contains(report.Images.Kept, "app:1") || !contains(report.Images.Kept, r.built) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	c, err := tp.daemon.ContainerInspect(context.Background(), r.container)
+	if err != nil || c.State.Running {
+		t.Errorf("expected container to be stopped and kept, got %v", err)
+	}
+	tp.expectImages(true, "app:1", "mine:1")
+}
+
+func TestTeardownKeepForSomeTime(t *testing.T) {
+	policy := TeardownPolicy{Containers: "24h", Volumes: "24h", Images: "24h"}
+	tp := newTestProxy(t, Policy{Teardown: policy})
+	defer tp.Close()
+	r := tp.tenantResources()
+	ctx := context.Background()
+	// left by a previous session, with an expired retention
+	if _, err := tp.daemon.VolumeCreate(ctx, volumetypes.VolumesCreateBody{Name: "old", Labels: map[string]string{RetentionLabel: "1ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := tp.proxy.Teardown()
+	if len(report.Containers.Removed)+len(report.Volumes.Removed)+len(report.Images.Removed) > 0 ||
+		!reflect.DeepEqual(report.Containers.Kept, []string{r.container}) || !reflect.DeepEqual(report.Volumes.Kept, []string{"data"}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// resources are labelled so a later sweep removes them once expired
+	c, _ := tp.daemon.ContainerInspect(ctx, r.container)
+	v, _ := tp.daemon.VolumeInspect(ctx, "data")
+	i, _, _ := tp.daemon.ImageInspectWithRaw(ctx, r.built)
+	for kind, labels := range map[string]map[string]string{"container": c.Config.Labels, "volume": v.Labels, "image": i.Config.Labels} {
+		if labels[RetentionLabel] != "24h" {
+			t.Errorf("expected %s to be labelled with retention, got %v", kind, labels)
+		}
+	}
+
+	sweep := tp.proxy.Sweep()
+	if len(sweep.Containers.Removed)+len(sweep.Images.Removed) > 0 || !reflect.DeepEqual(sweep.Volumes.Removed, []string{"old"}) {
+		t.Errorf("expected only expired volume to be swept, got %+v", sweep)
+	}
+}
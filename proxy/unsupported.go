@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// defaults set by docker CLI on fields we don't forward, which don't have to be reported as dropped
+var clientDefaults = map[string]string{
+	"HostConfig.NetworkMode":        "default",
+	"HostConfig.RestartPolicy.Name": "no",
+	"HostConfig.MemorySwappiness":   "-1",
+}
+
+/**
+ List non-empty fields of a decoded request which are not forwarded to daemon, as dotted paths like
+ `Config.Healthcheck` or `Config.Labels.foo` for map entries.
+ */
+func unsupportedFields(name string, requested, forwarded interface{}) []string {
+	dropped := []string{}
+	collectDropped(name, reflect.ValueOf(requested), reflect.ValueOf(forwarded), &dropped)
+	sort.Strings(dropped)
+	return dropped
+}
+
+func collectDropped(path string, requested, forwarded reflect.Value, dropped *[]string) {
+	for requested.Kind() == reflect.Ptr || requested.Kind() == reflect.Interface {
+		if requested.IsNil() {
+			return
+		}
+		requested = requested.Elem()
+	}
+	for forwarded.Kind() == reflect.Ptr || forwarded.Kind() == reflect.Interface {
+		if forwarded.IsNil() {
+			if !isZero(requested) && !isClientDefault(path, requested) {
+				*dropped = append(*dropped, path)
+			}
+			return
+		}
+		forwarded = forwarded.Elem()
+	}
+
+	switch requested.Kind() {
+	case reflect.Struct:
+		t := requested.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			p := path + "." + f.Name
+			if f.Anonymous {
+				p = path
+			}
+			collectDropped(p, requested.Field(i), forwarded.Field(i), dropped)
+		}
+	case reflect.Map:
+		for _, k := range requested.MapKeys() {
+			if forwarded.Len() == 0 || !forwarded.MapIndex(k).IsValid() {
+				*dropped = append(*dropped, fmt.Sprintf("%s.%v", path, k.Interface()))
+			}
+		}
+	default:
+		if !isZero(requested) && isZero(forwarded) && !isClientDefault(path, requested) {
+			*dropped = append(*dropped, path)
+		}
+	}
+}
+
+func isClientDefault(path string, v reflect.Value) bool {
+	d, ok := clientDefaults[path]
+	return ok && fmt.Sprint(v.Interface()) == d
+}
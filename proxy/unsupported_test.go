@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/net/context"
+)
+
+// create request with fields Lancelot doesn't forward, and a client default which isn't reported
+var unsupportedCreate = map[string]interface{}{
+	"Image":       "busybox",
+	"Healthcheck": map[string]interface{}{"Test": []string{"CMD", "true"}, "Interval": 1000000000},
+	"StopSignal":  "SIGUSR1",
+	"HostConfig": map[string]interface{}{
+		"NetworkMode":   "default",
+		"RestartPolicy": map[string]interface{}{"Name": "always"},
+	},
+}
+
+func TestUnsupportedFieldsAreWarned(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	body := container.ContainerCreateCreatedBody{}
+	tp.decode(tp.do("POST", "/containers/create", unsupportedCreate), http.StatusCreated, &body)
+	expected := []string{
+		"Config.Healthcheck is not supported by Lancelot and has been ignored",
+		"Config.StopSignal is not supported by Lancelot and has been ignored",
+		"HostConfig.RestartPolicy.Name is not supported by Lancelot and has been ignored",
+	}
+	if !reflect.DeepEqual(body.Warnings, expected) {
+		t.Fatalf("expected dropped fields to be warned, got %v", body.Warnings)
+	}
+
+	json, err := tp.daemon.ContainerInspect(context.Background(), body.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.Config.Healthcheck != nil || json.Config.StopSignal != "" || json.HostConfig.RestartPolicy.Name != "" {
+		t.Fatalf("expected dropped fields not to be forwarded, got %+v %+v", json.Config, json.HostConfig.RestartPolicy)
+	}
+}
+
+func TestUnsupportedFieldsAreRejectedInStrictMode(t *testing.T) {
+	tp := newTestProxy(t, Policy{Strict: true})
+	defer tp.Close()
+
+	tp.expectError(tp.do("POST", "/containers/create", unsupportedCreate), http.StatusBadRequest,
+		"Unsupported parameters: Config.Healthcheck, Config.StopSignal, HostConfig.RestartPolicy.Name")
+	list, _ := tp.daemon.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if len(list) != 0 {
+		t.Fatalf("expected no container to be created, got %v", list)
+	}
+
+	// supported fields only
+	tp.create(map[string]interface{}{"Image": "busybox", "Cmd": []string{"cat"}, "HostConfig": map[string]interface{}{"NetworkMode": "default"}}, "")
+}
+
+func TestUnsupportedMapEntries(t *testing.T) {
+	requested := &container.Config{Labels: map[string]string{"a": "1", "b": "2"}, Env: []string{"A=1"}}
+	forwarded := &container.Config{Labels: map[string]string{"a": "1"}, Env: []string{"A=1"}}
+	if dropped := unsupportedFields("Config", requested, forwarded); !reflect.DeepEqual(dropped, []string{"Config.Labels.b"}) {
+		t.Fatalf("expected dropped label to be reported, got %v", dropped)
+	}
+}
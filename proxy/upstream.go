@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// recordingClient records calls to daemon in the exchange being recorded, if any. Only calls made by proxy handlers
+// are decorated, others are forwarded as is.
+type recordingClient struct {
+	client.APIClient
+}
+
+/**
+ Decorate a client so upstream calls get recorded with the request which triggered them
+ */
+func (rec *Recorder) Client(c client.APIClient) client.APIClient {
+	return &recordingClient{c}
+}
+
+func record(ctx context.Context, call, resource string, err error) {
+	if e := exchange(ctx); e != nil {
+		e.call(call, resource, err)
+	}
+}
+
+func (c *recordingClient) ContainerAttach(ctx context.Context, container string, options types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	res, err := c.APIClient.ContainerAttach(ctx, container, options)
+	record(ctx, "ContainerAttach", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	res, err := c.APIClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, containerName)
+	record(ctx, "ContainerCreate", res.ID, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerExecAttach(ctx context.Context, execID string, config types.ExecConfig) (types.HijackedResponse, error) {
+	res, err := c.APIClient.ContainerExecAttach(ctx, execID, config)
+	record(ctx, "ContainerExecAttach", execID, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error) {
+	res, err := c.APIClient.ContainerExecCreate(ctx, container, config)
+	record(ctx, "ContainerExecCreate", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	res, err := c.APIClient.ContainerExecInspect(ctx, execID)
+	record(ctx, "ContainerExecInspect", execID, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerExecResize(ctx context.Context, execID string, options types.ResizeOptions) error {
+	err := c.APIClient.ContainerExecResize(ctx, execID, options)
+	record(ctx, "ContainerExecResize", execID, err)
+	return err
+}
+
+func (c *recordingClient) ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error) {
+	res, err := c.APIClient.ContainerInspect(ctx, container)
+	record(ctx, "ContainerInspect", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerKill(ctx context.Context, container, signal string) error {
+	err := c.APIClient.ContainerKill(ctx, container, signal)
+	record(ctx, "ContainerKill", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	res, err := c.APIClient.ContainerList(ctx, options)
+	record(ctx, "ContainerList", "", err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	res, err := c.APIClient.ContainerLogs(ctx, container, options)
+	record(ctx, "ContainerLogs", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerPause(ctx context.Context, container string) error {
+	err := c.APIClient.ContainerPause(ctx, container)
+	record(ctx, "ContainerPause", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error {
+	err := c.APIClient.ContainerRemove(ctx, container, options)
+	record(ctx, "ContainerRemove", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerResize(ctx context.Context, container string, options types.ResizeOptions) error {
+	err := c.APIClient.ContainerResize(ctx, container, options)
+	record(ctx, "ContainerResize", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerRestart(ctx context.Context, container string, timeout *time.Duration) error {
+	err := c.APIClient.ContainerRestart(ctx, container, timeout)
+	record(ctx, "ContainerRestart", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerStart(ctx context.Context, container string, options types.ContainerStartOptions) error {
+	err := c.APIClient.ContainerStart(ctx, container, options)
+	record(ctx, "ContainerStart", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerStatPath(ctx context.Context, container, path string) (types.ContainerPathStat, error) {
+	res, err := c.APIClient.ContainerStatPath(ctx, container, path)
+	record(ctx, "ContainerStatPath", container+":"+path, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerStop(ctx context.Context, container string, timeout *time.Duration) error {
+	err := c.APIClient.ContainerStop(ctx, container, timeout)
+	record(ctx, "ContainerStop", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerTop(ctx context.Context, container string, arguments []string) (container.ContainerTopOKBody, error) {
+	res, err := c.APIClient.ContainerTop(ctx, container, arguments)
+	record(ctx, "ContainerTop", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerUnpause(ctx context.Context, container string) error {
+	err := c.APIClient.ContainerUnpause(ctx, container)
+	record(ctx, "ContainerUnpause", container, err)
+	return err
+}
+
+func (c *recordingClient) ContainerUpdate(ctx context.Context, container string, updateConfig container.UpdateConfig) (container.ContainerUpdateOKBody, error) {
+	res, err := c.APIClient.ContainerUpdate(ctx, container, updateConfig)
+	record(ctx, "ContainerUpdate", container, err)
+	return res, err
+}
+
+func (c *recordingClient) ContainerWait(ctx context.Context, container string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	record(ctx, "ContainerWait", container, nil)
+	return c.APIClient.ContainerWait(ctx, container, condition)
+}
+
+func (c *recordingClient) CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	res, stat, err := c.APIClient.CopyFromContainer(ctx, container, srcPath)
+	record(ctx, "CopyFromContainer", container+":"+srcPath, err)
+	return res, stat, err
+}
+
+func (c *recordingClient) CopyToContainer(ctx context.Context, container, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	err := c.APIClient.CopyToContainer(ctx, container, path, content, options)
+	record(ctx, "CopyToContainer", container+":"+path, err)
+	return err
+}
+
+func (c *recordingClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	res, err := c.APIClient.DistributionInspect(ctx, image, encodedRegistryAuth)
+	record(ctx, "DistributionInspect", image, err)
+	return res, err
+}
+
+func (c *recordingClient) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	res, err := c.APIClient.DiskUsage(ctx)
+	record(ctx, "DiskUsage", "", err)
+	return res, err
+}
+
+func (c *recordingClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	record(ctx, "Events", "", nil)
+	return c.APIClient.Events(ctx, options)
+}
+
+func (c *recordingClient) ImageBuild(ctx context.Context, context io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	res, err := c.APIClient.ImageBuild(ctx, context, options)
+	tag := ""
+	if len(options.Tags) > 0 {
+		tag = options.Tags[0]
+	}
+	record(ctx, "ImageBuild", tag, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageCreate(ctx context.Context, parentReference string, options types.ImageCreateOptions) (io.ReadCloser, error) {
+	res, err := c.APIClient.ImageCreate(ctx, parentReference, options)
+	record(ctx, "ImageCreate", parentReference, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageHistory(ctx context.Context, image string) ([]image.HistoryResponseItem, error) {
+	res, err := c.APIClient.ImageHistory(ctx, image)
+	record(ctx, "ImageHistory", image, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	res, raw, err := c.APIClient.ImageInspectWithRaw(ctx, image)
+	record(ctx, "ImageInspect", image, err)
+	return res, raw, err
+}
+
+func (c *recordingClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	res, err := c.APIClient.ImageList(ctx, options)
+	record(ctx, "ImageList", "", err)
+	return res, err
+}
+
+func (c *recordingClient) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	res, err := c.APIClient.ImagePull(ctx, ref, options)
+	record(ctx, "ImagePull", ref, err)
+	return res, err
+}
+
+func (c *recordingClient) ImagePush(ctx context.Context, ref string, options types.ImagePushOptions) (io.ReadCloser, error) {
+	res, err := c.APIClient.ImagePush(ctx, ref, options)
+	record(ctx, "ImagePush", ref, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	res, err := c.APIClient.ImageRemove(ctx, image, options)
+	record(ctx, "ImageRemove", image, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageSearch(ctx context.Context, term string, options types.ImageSearchOptions) ([]registry.SearchResult, error) {
+	res, err := c.APIClient.ImageSearch(ctx, term, options)
+	record(ctx, "ImageSearch", term, err)
+	return res, err
+}
+
+func (c *recordingClient) ImageTag(ctx context.Context, image, ref string) error {
+	err := c.APIClient.ImageTag(ctx, image, ref)
+	record(ctx, "ImageTag", image+" "+ref, err)
+	return err
+}
+
+func (c *recordingClient) Info(ctx context.Context) (types.Info, error) {
+	res, err := c.APIClient.Info(ctx)
+	record(ctx, "Info", "", err)
+	return res, err
+}
+
+func (c *recordingClient) NetworkConnect(ctx context.Context, networkID, container string, config *network.EndpointSettings) error {
+	err := c.APIClient.NetworkConnect(ctx, networkID, container, config)
+	record(ctx, "NetworkConnect", networkID+" "+container, err)
+	return err
+}
+
+func (c *recordingClient) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	res, err := c.APIClient.NetworkCreate(ctx, name, options)
+	record(ctx, "NetworkCreate", name, err)
+	return res, err
+}
+
+func (c *recordingClient) NetworkDisconnect(ctx context.Context, networkID, container string, force bool) error {
+	err := c.APIClient.NetworkDisconnect(ctx, networkID, container, force)
+	record(ctx, "NetworkDisconnect", networkID+" "+container, err)
+	return err
+}
+
+func (c *recordingClient) NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	res, err := c.APIClient.NetworkInspect(ctx, networkID, options)
+	record(ctx, "NetworkInspect", networkID, err)
+	return res, err
+}
+
+func (c *recordingClient) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	res, err := c.APIClient.NetworkList(ctx, options)
+	record(ctx, "NetworkList", "", err)
+	return res, err
+}
+
+func (c *recordingClient) NetworkRemove(ctx context.Context, networkID string) error {
+	err := c.APIClient.NetworkRemove(ctx, networkID)
+	record(ctx, "NetworkRemove", networkID, err)
+	return err
+}
+
+func (c *recordingClient) Ping(ctx context.Context) (types.Ping, error) {
+	res, err := c.APIClient.Ping(ctx)
+	record(ctx, "Ping", "", err)
+	return res, err
+}
+
+func (c *recordingClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	res, err := c.APIClient.ServerVersion(ctx)
+	record(ctx, "ServerVersion", "", err)
+	return res, err
+}
+
+func (c *recordingClient) VolumeCreate(ctx context.Context, options volumetypes.VolumesCreateBody) (types.Volume, error) {
+	res, err := c.APIClient.VolumeCreate(ctx, options)
+	record(ctx, "VolumeCreate", res.Name, err)
+	return res, err
+}
+
+func (c *recordingClient) VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error) {
+	res, err := c.APIClient.VolumeInspect(ctx, volumeID)
+	record(ctx, "VolumeInspect", volumeID, err)
+	return res, err
+}
+
+func (c *recordingClient) VolumeList(ctx context.Context, filter filters.Args) (volumetypes.VolumesListOKBody, error) {
+	res, err := c.APIClient.VolumeList(ctx, filter)
+	record(ctx, "VolumeList", "", err)
+	return res, err
+}
+
+func (c *recordingClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	err := c.APIClient.VolumeRemove(ctx, volumeID, force)
+	record(ctx, "VolumeRemove", volumeID, err)
+	return err
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/gorilla/mux"
+)
+
+// Version of Lancelot, set at build time with -ldflags "-X github.com/cloudbees/lancelot/proxy.Version=..."
+var Version = "dev"
+
+// DefaultMinAPIVersion is the oldest API version we accept by default, same as docker engine
+const DefaultMinAPIVersion = "1.12"
+
+// ComponentVersion describes API versions supported by a component of the proxied stack
+type ComponentVersion struct {
+	Version       string
+	APIVersion    string `json:"ApiVersion"`
+	MinAPIVersion string `json:"MinAPIVersion,omitempty"`
+}
+
+// VersionResponse is the docker version response, with API version range served by the proxy, and details on both
+// proxy and actual daemon versions
+type VersionResponse struct {
+	types.Version
+	Lancelot ComponentVersion
+	Daemon   ComponentVersion
+}
+
+func (p *Proxy) SetMinAPIVersion(version string) {
+	p.minAPIVersion = version
+}
+
+/**
+ Oldest API version we accept
+ */
+func (p *Proxy) MinAPIVersion() string {
+	if p.minAPIVersion == "" {
+		return DefaultMinAPIVersion
+	}
+	return p.minAPIVersion
+}
+
+/**
+ Latest API version we serve, which is the one negotiated with the daemon
+ */
+func (p *Proxy) MaxAPIVersion() string {
+	if p.client == nil || versions.GreaterThan(p.client.ClientVersion(), api.DefaultVersion) {
+		return api.DefaultVersion
+	}
+	return p.client.ClientVersion()
+}
+
+/**
+ API version used by client for this request
+ */
+func apiVersion(r *http.Request) string {
+	if v := mux.Vars(r)["version"]; v != "" {
+		return v
+	}
+	return api.DefaultVersion
+}
+
+/**
+ Check API version requested by client is in the range we serve, and set versioning headers, as docker engine does.
+ */
+func (p *Proxy) versioned(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", fmt.Sprintf("Lancelot/%s (%s)", Version, runtime.GOOS))
+		w.Header().Set("API-Version", p.MaxAPIVersion())
+		w.Header().Set("OSType", runtime.GOOS)
+
+		if v := mux.Vars(r)["version"]; v != "" {
+			if versions.LessThan(v, p.MinAPIVersion()) {
+				writeError(w, r, badRequest("client version %s is too old. Minimum supported API version is %s, please upgrade your client to a newer version", v, p.MinAPIVersion()))
+				return
+			}
+			if versions.GreaterThan(v, p.MaxAPIVersion()) {
+				writeError(w, r, badRequest("client version %s is too new. Maximum supported API version is %s", v, p.MaxAPIVersion()))
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// legacyInfo is the info response for API < 1.25
+type legacyInfo struct {
+	types.Info
+	ExecutionDriver string
+}
+
+// downgrades convert responses for clients using an API older than `Before`
+var downgrades = []struct {
+	Before  string
+	Convert func(v interface{}) interface{}
+}{
+	{
+		Before: "1.25",
+		Convert: func(v interface{}) interface{} {
+			info, ok := v.(types.Info)
+			if !ok {
+				return v
+			}
+			// security options used to be plain names
+			names := []string{}
+			if opts, err := types.DecodeSecurityOptions(info.SecurityOptions); err == nil {
+				for _, o := range opts {
+					names = append(names, o.Name)
+				}
+			}
+			info.SecurityOptions = names
+			return legacyInfo{Info: info, ExecutionDriver: "<not supported>"}
+		},
+	},
+	{
+		Before: "1.25",
+		Convert: func(v interface{}) interface{} {
+			version, ok := v.(VersionResponse)
+			if !ok {
+				return v
+			}
+			version.MinAPIVersion = ""
+			return version
+		},
+	},
+}
+
+/**
+ Convert a response for the API version used by client
+ */
+func downgrade(version string, v interface{}) interface{} {
+	for _, d := range downgrades {
+		if versions.LessThan(version, d.Before) {
+			v = d.Convert(v)
+		}
+	}
+	return v
+}
+
+/**
+ Write JSON response, converted for the API version used by client
+ */
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) error {
+	return httputils.WriteJSON(w, code, downgrade(apiVersion(r), v))
+}
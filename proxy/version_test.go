@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInfoIsDowngradedForOldClients(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	info := map[string]interface{}{}
+	tp.decode(tp.do("GET", "/v1.24/info", nil), http.StatusOK, &info)
+	if !reflect.DeepEqual(info["SecurityOptions"], []interface{}{"seccomp", "apparmor"}) || info["ExecutionDriver"] != "<not supported>" {
+		t.Fatalf("expected security options names and execution driver, got %v and %v", info["SecurityOptions"], info["ExecutionDriver"])
+	}
+
+	info = map[string]interface{}{}
+	tp.decode(tp.do("GET", "/v1.25/info", nil), http.StatusOK, &info)
+	if _, ok := info["ExecutionDriver"]; ok || !reflect.DeepEqual(info["SecurityOptions"], []interface{}{"name=seccomp,profile=default", "name=apparmor"}) {
+		t.Fatalf("expected info as is, got %v and %v", info["SecurityOptions"], info["ExecutionDriver"])
+	}
+}
+
+func TestVersionReportsProxyAndDaemon(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.proxy.SetMinAPIVersion("1.24")
+
+	version := VersionResponse{}
+	res := tp.do("GET", "/v1.31/version", nil)
+	if res.Header.Get("API-Version") != "1.31" {
+		t.Errorf("expected API-Version header, got %v", res.Header)
+	}
+	tp.decode(res, http.StatusOK, &version)
+	if version.APIVersion != "1.31" || version.MinAPIVersion != "1.24" || version.Version.Version != "17.06.0-fake" {
+		t.Errorf("expected API versions served by proxy, got %+v", version.Version)
+	}
+	if version.Lancelot != (ComponentVersion{Version: Version, APIVersion: "1.31", MinAPIVersion: "1.24"}) {
+		t.Errorf("unexpected Lancelot version %+v", version.Lancelot)
+	}
+	if version.Daemon != (ComponentVersion{Version: "17.06.0-fake", APIVersion: "1.31", MinAPIVersion: "1.12"}) {
+		t.Errorf("unexpected daemon version %+v", version.Daemon)
+	}
+
+	// at minimum version, MinAPIVersion didn't exist yet
+	old := map[string]interface{}{}
+	tp.decode(tp.do("GET", "/v1.24/version", nil), http.StatusOK, &old)
+	if _, ok := old["MinAPIVersion"]; ok || old["ApiVersion"] != "1.31" {
+		t.Errorf("expected version without MinAPIVersion, got %v", old)
+	}
+	if lancelot, ok := old["Lancelot"].(map[string]interface{}); !ok || lancelot["MinAPIVersion"] != "1.24" {
+		t.Errorf("expected Lancelot versions to be reported, got %v", old["Lancelot"])
+	}
+
+	// errors are plain text before 1.24
+	if b := tp.expect(tp.do("GET", "/v1.23/version", nil), http.StatusBadRequest); !strings.Contains(string(b), "client version 1.23 is too old. Minimum supported API version is 1.24") {
+		t.Errorf("expected version below minimum to be rejected, got %s", b)
+	}
+}
+
+func TestAPIVersionRange(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expect(tp.do("GET", "/v1.12/version", nil), http.StatusOK)
+	if b := tp.expect(tp.do("GET", "/v1.11/version", nil), http.StatusBadRequest); !strings.Contains(string(b), "Minimum supported API version is 1.12") {
+		t.Errorf("expected version below minimum to be rejected, got %s", b)
+	}
+	tp.expectError(tp.do("GET", "/v1.32/version", nil), http.StatusBadRequest, "client version 1.32 is too new. Maximum supported API version is 1.31")
+
+	tp.proxy.SetMinAPIVersion("1.25")
+	tp.expectError(tp.do("GET", "/v1.24/containers/json", nil), http.StatusBadRequest, "client version 1.24 is too old")
+	tp.expect(tp.do("GET", "/v1.25/containers/json", nil), http.StatusOK)
+}
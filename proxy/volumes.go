@@ -2,41 +2,43 @@ package proxy
 
 import (
 	"net/http"
+	"sort"
+	"strings"
 	"github.com/docker/docker/api/server/httputils"
-	"context"
 	volumetypes "github.com/docker/docker/api/types/volume"
-	"encoding/json"
-	"fmt"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
 	"github.com/gorilla/mux"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/net/context"
 )
 
 func (p *Proxy) volumeList(w http.ResponseWriter, r *http.Request) {
 
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	filters, err := filters.FromParam(r.Form.Get("filters"))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	volumes, err := p.client.VolumeList(context.Background(), filters)
+	volumes, err := p.client.VolumeList(r.Context(), filters)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	filtered := []*types.Volume{}
 	for _, v := range volumes.Volumes {
 		if _, err := p.ownsVolume(v.Name); err == nil {
+			v.Name = p.clientName(v.Name)
 			filtered = append(filtered, v)
 		}
 	}
@@ -51,65 +53,145 @@ func (p *Proxy) volumeList(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (p *Proxy) volumeCreate(w http.ResponseWriter, r *http.Request) {
-
+func (p *Proxy) volumeDelete(w http.ResponseWriter, r *http.Request) {
 	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
-
 	}
 
-	if err := httputils.CheckForJSON(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	vars := mux.Vars(r)
+	name, err := p.ownsVolume(vars["name"]);
+	if err != nil {
+		writeError(w, r, err)
 		return
-
 	}
-
-	var req volumetypes.VolumesCreateBody
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	force := httputils.BoolValue(r, "force")
+	if err := p.client.VolumeRemove(r.Context(), name, force); err != nil {
+		writeError(w, r, p.clientError(err))
 		return
-
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	volume, err := p.client.VolumeCreate(context.Background(), volumetypes.VolumesCreateBody{
-		Driver: req.Driver,
-		DriverOpts: req.DriverOpts,
-		Labels: req.Labels,
-		Name: req.Name,
-	})
+// tmpfs mount options a local volume can set, others like `bind` would let it mount a host path
+var tmpfsVolumeOptions = []string{"size", "nr_inodes", "mode", "uid", "gid"}
+
+/**
+ Check volume driver options are safe. Local driver mounts its `device` with `type` and `o` options as given, so
+ `type=none,o=bind,device=/` would be a bind mount of host root: only tmpfs volumes are accepted, and other drivers
+ can't get options. A tmpfs volume must be sized up to limit, as tmpfs mounts are.
+ */
+func checkVolumeDriver(driver string, options map[string]string, limit int64) error {
+	if len(options) == 0 {
+		return nil
+	}
+	if driver != "" && driver != "local" {
+		return denied("options of volume driver %s are not authorized", driver)
+	}
+	switch t, ok := options["type"]; {
+	case !ok:
+		return denied("volume options are not authorized without type=tmpfs")
+	case t != "tmpfs":
+		return denied("volume option type=%s is not authorized, only tmpfs volumes can be configured", t)
+	}
+	keys := []string{}
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := options[k]
+		switch k {
+		case "type", "device":
+			if v != "tmpfs" {
+				return denied("volume option %s=%s is not authorized, only tmpfs volumes can be configured", k, v)
+			}
+		case "o":
+			for _, o := range strings.Split(v, ",") {
+				if !contains(tmpfsVolumeOptions, strings.SplitN(o, "=", 2)[0]) {
+					return denied("volume mount option %s is not authorized", o)
+				}
+			}
+		default:
+			return denied("volume option %s is not authorized", k)
+		}
+	}
+	size, err := tmpfsVolumeSize(options)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
+	}
+	if size <= 0 {
+		return denied("tmpfs volume must set a size, up to %s", units.BytesSize(float64(limit)))
+	}
+	if size > limit {
+		return denied("tmpfs volume size %s exceeds limit %s", units.BytesSize(float64(size)), units.BytesSize(float64(limit)))
 	}
+	return nil
+}
 
-	p.addVolume(volume.Name)
-	httputils.WriteJSON(w, http.StatusCreated, volume)
+/**
+ Size of a tmpfs volume from its local driver options, 0 for other volumes or when not set
+ */
+func tmpfsVolumeSize(options map[string]string) (int64, error) {
+	if options["type"] != "tmpfs" {
+		return 0, nil
+	}
+	for _, o := range strings.Split(options["o"], ",") {
+		if strings.HasPrefix(o, "size=") {
+			size, err := units.RAMInBytes(strings.TrimPrefix(o, "size="))
+			if err != nil {
+				return 0, badRequest("Invalid tmpfs volume size: %s", err.Error())
+			}
+			return size, nil
+		}
+	}
+	return 0, nil
 }
 
-func (p *Proxy) volumeDelete(w http.ResponseWriter, r *http.Request) {
-	if err := httputils.ParseForm(r); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+/**
+ Memory taken by tmpfs volumes a container mounts, which are charged to its memory cgroup like its tmpfs mounts.
+ Options of a volume given in Mounts only apply when daemon creates it.
+ */
+func (p *Proxy) tmpfsVolumesSize(ctx context.Context, hostConfig *container.HostConfig) (int64, error) {
+	total := int64(0)
+	seen := map[string]bool{}
+	add := func(name string, options map[string]string) error {
+		if name != "" {
+			if seen[name] {
+				return nil
+			}
+			seen[name] = true
+			volume, err := p.client.VolumeInspect(ctx, name)
+			if err == nil {
+				options = volume.Options
+			} else if !client.IsErrNotFound(err) {
+				return p.clientError(err)
+			}
+		}
+		size, err := tmpfsVolumeSize(options)
+		total += size
+		return err
 	}
 
-	vars := mux.Vars(r)
-	name, err := p.ownsVolume(vars["name"]);
-	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, b := range hostConfig.Binds {
+		if b[:1] == "/" {
+			continue
+		}
+		if err := add(strings.SplitN(b, ":", 2)[0], nil); err != nil {
+			return 0, err
+		}
 	}
-	force := httputils.BoolValue(r, "force")
-	if err := p.client.VolumeRemove(context.Background(), name, force); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, m := range hostConfig.Mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+		var options map[string]string
+		if m.VolumeOptions != nil && m.VolumeOptions.DriverConfig != nil {
+			options = m.VolumeOptions.DriverConfig.Options
+		}
+		if err := add(m.Source, options); err != nil {
+			return 0, err
+		}
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+	return total, nil
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVolumeDriverOptions(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{
+		"Name": "scratch", "DriverOpts": map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=64m,uid=1000"},
+	}), http.StatusCreated)
+
+	for message, options := range map[string]map[string]string{
+		"volume option type=none is not authorized":      {"type": "none", "o": "bind", "device": "/"},
+		"volume mount option bind is not authorized":     {"type": "tmpfs", "o": "size=1m,bind"},
+		"volume option device=/etc is not authorized":    {"type": "tmpfs", "device": "/etc"},
+		"volume options are not authorized without type": {"o": "size=1m"},
+		"volume option mountpoint is not authorized":     {"type": "tmpfs", "mountpoint": "/"},
+		"tmpfs volume must set a size, up to 64MiB":      {"type": "tmpfs", "device": "tmpfs", "o": "uid=1000"},
+		"tmpfs volume size 1GiB exceeds limit 64MiB":     {"type": "tmpfs", "device": "tmpfs", "o": "size=1g"},
+	} {
+		tp.expectError(tp.do("POST", "/volumes/create", map[string]interface{}{"Name": "evil", "DriverOpts": options}), http.StatusForbidden, message)
+	}
+	tp.expectError(tp.do("POST", "/volumes/create", map[string]interface{}{
+		"Name": "evil", "Driver": "rexray", "DriverOpts": map[string]string{"size": "1"},
+	}), http.StatusForbidden, "options of volume driver rexray are not authorized")
+}
+
+func TestVolumeMountDriverOptions(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	volume := func(options map[string]string) map[string]interface{} {
+		return map[string]interface{}{
+			"Image": "busybox",
+			"HostConfig": map[string]interface{}{"Mounts": []map[string]interface{}{{
+				"Type": "volume", "Source": "data", "Target": "/data",
+				"VolumeOptions": map[string]interface{}{"DriverConfig": map[string]interface{}{"Name": "local", "Options": options}},
+			}}},
+		}
+	}
+	// --mount type=volume,volume-driver=local,volume-opt=type=none,volume-opt=o=bind,volume-opt=device=/
+	tp.expectError(tp.do("POST", "/containers/create", volume(map[string]string{"type": "none", "o": "bind", "device": "/"})), http.StatusForbidden, "volume option type=none is not authorized")
+	tp.create(volume(map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=10m"}), "")
+}
+
+func TestTmpfsVolumesCountAsTenantMemory(t *testing.T) {
+	tp := newTestProxy(t, Policy{Storage: StoragePolicy{TmpfsSize: "100m", ShmSize: "100m"}})
+	defer tp.Close()
+	tp.proxy.SetCgroupLimits(CgroupLimits{Memory: 250 * 1024 * 1024})
+
+	tp.expect(tp.do("POST", "/volumes/create", map[string]interface{}{
+		"Name": "scratch", "DriverOpts": map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=100m"},
+	}), http.StatusCreated)
+	tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"scratch:/scratch"}},
+	}, "")
+
+	inline := map[string]interface{}{
+		"Type": "volume", "Source": "other", "Target": "/other",
+		"VolumeOptions": map[string]interface{}{"DriverConfig": map[string]interface{}{
+			"Name": "local", "Options": map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=100m"},
+		}},
+	}
+	for _, hostConfig := range []map[string]interface{}{
+		{"Binds": []string{"scratch:/scratch"}, "Tmpfs": map[string]string{"/tmp": "size=100m"}},
+		{"Binds": []string{"scratch:/scratch"}, "Mounts": []map[string]interface{}{inline}},
+	} {
+		tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+			"Image":      "busybox",
+			"HostConfig": hostConfig,
+		}), http.StatusForbidden, "add up to 300MiB, more than tenant memory 250MiB")
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Parent is the container owning the cgroup all sidecars are placed in
+	Parent = "parent"
+	// Root is the first sidecar container, started from Lancelot command line
+	Root = "root"
+)
+
+// Death of a container Lancelot depends on
+type Death struct {
+	ID       string
+	Role     string
+	OOM      bool
+	ExitCode string
+}
+
+func (d Death) String() string {
+	s := fmt.Sprintf("%s container %s exited with code %s", d.Role, d.ID, d.ExitCode)
+	if d.OOM {
+		s = s + " (OOM killed)"
+	}
+	return s
+}
+
+/**
+ Next container to be created will be considered the root container
+ */
+func (p *Proxy) ExpectRoot() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.expectRoot = true
+}
+
+func (p *Proxy) recordRoot(id string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.expectRoot {
+		fmt.Printf("recording root container %s\n", id)
+		p.root = id
+		p.expectRoot = false
+	}
+}
+
+/**
+ Tell which role a container plays for us, if any
+ */
+func (p *Proxy) role(id string) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	switch {
+	case p.cgroup.ContainerID != "" && strings.HasPrefix(id, p.cgroup.ContainerID):
+		return Parent
+	case p.root != "" && id == p.root:
+		return Root
+	}
+	return ""
+}
+
+/**
+ Follow parent and root containers through daemon event stream, and notify when one of them dies.
+ Event stream is re-opened if interrupted, until context is cancelled. Daemon then sends again events since the last
+ one received, which are skipped so each death is only notified once.
+ */
+func (p *Proxy) Watch(ctx context.Context) <-chan Death {
+	deaths := make(chan Death, 2)
+
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("event", "oom")
+	args.Add("event", "die")
+
+	go func() {
+		oom := map[string]bool{}
+		since := ""
+		var last int64
+		handled := map[string]bool{} // events received at time last
+		for {
+			msgs, errs := p.client.Events(ctx, types.EventsOptions{
+				Since:   since,
+				Filters: args,
+			})
+		stream:
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-errs:
+					fmt.Printf("Event stream interrupted: %s\n", err.Error())
+					break stream
+				case m := <-msgs:
+					key := m.Action + " " + m.Actor.ID
+					if m.TimeNano < last || (m.TimeNano == last && handled[key]) {
+						continue
+					}
+					if m.TimeNano > last {
+						last, handled = m.TimeNano, map[string]bool{}
+					}
+					handled[key] = true
+					since = fmt.Sprintf("%d.%09d", m.TimeNano/int64(time.Second), m.TimeNano%int64(time.Second))
+					role := p.role(m.Actor.ID)
+					if role == "" {
+						continue
+					}
+					switch m.Action {
+					case "oom":
+						oom[m.Actor.ID] = true
+					case "die":
+						deaths <- Death{
+							ID:       m.Actor.ID,
+							Role:     role,
+							OOM:      oom[m.Actor.ID],
+							ExitCode: m.Actor.Attributes["exitCode"],
+						}
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return deaths
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+/**
+ Wait for next death notified by Watch
+ */
+func nextDeath(t *testing.T, deaths <-chan Death) Death {
+	t.Helper()
+	select {
+	case d := <-deaths:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a container death")
+	}
+	return Death{}
+}
+
+func TestWatchDeaths(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parent := tp.foreignContainer()
+	cgroup := CgroupOverride(testCgroup)
+	cgroup.ContainerID = parent
+	tp.proxy.SetCgroup(cgroup)
+	tp.proxy.ExpectRoot()
+	root := tp.run("busybox")
+	other := tp.run("busybox")
+
+	deaths := tp.proxy.Watch(ctx)
+	// let Watch subscribe, events before that are not replayed
+	time.Sleep(100 * time.Millisecond)
+
+	tp.daemon.ContainerKill(ctx, other, "KILL")
+	tp.daemon.ContainerKill(ctx, root, "KILL")
+	if d := nextDeath(t, deaths); d.ID != root || d.Role != Root || d.ExitCode != "137" {
+		t.Fatalf("unexpected death %s", d)
+	}
+	tp.daemon.ContainerKill(ctx, parent, "KILL")
+	if d := nextDeath(t, deaths); d.ID != parent || d.Role != Parent {
+		t.Fatalf("unexpected death %s", d)
+	}
+
+	// on reconnect, daemon sends again the events handled before interruption
+	tp.daemon.InterruptEvents()
+	tp.daemon.ContainerStart(ctx, root, types.ContainerStartOptions{})
+	tp.daemon.ContainerStop(ctx, root, nil)
+	if d := nextDeath(t, deaths); d.ID != root || d.ExitCode != "0" {
+		t.Fatalf("expected only new death to be notified, got %s", d)
+	}
+	select {
+	case d := <-deaths:
+		t.Fatalf("unexpected death %s", d)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
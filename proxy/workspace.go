@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"path"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// callerVolume is a named volume mounted in a container calling Lancelot, like a Jenkins agent workspace. Sidecars
+// can share paths within it, as `docker.inside` does with `-v $WORKSPACE:$WORKSPACE`
+type callerVolume struct {
+	Container string
+	types.MountPoint
+}
+
+// bind options sidecars can set. Propagation ones are left out, so a sidecar can't leak mounts to host. Relabel ones
+// are denied on host paths
+var bindModes = []string{"ro", "rw", "z", "Z", "nocopy"}
+
+/**
+ Named volumes mounted in root container and the one Lancelot runs in, which are the ones calling Lancelot. Daemon
+ is only queried when a bind mount is requested.
+ */
+func (p *Proxy) workspaceVolumes(ctx context.Context, hostConfig *container.HostConfig) ([]callerVolume, error) {
+	binds := false
+	for _, b := range hostConfig.Binds {
+		binds = binds || path.IsAbs(b)
+	}
+	for _, m := range hostConfig.Mounts {
+		binds = binds || m.Type == mount.TypeBind
+	}
+	if !binds {
+		return nil, nil
+	}
+
+	p.mux.Lock()
+	callers := []string{}
+	if p.root != "" {
+		callers = append(callers, p.root)
+	}
+	if p.self != "" {
+		callers = append(callers, p.self)
+	}
+	p.mux.Unlock()
+
+	volumes := []callerVolume{}
+	for _, id := range callers {
+		json, err := p.client.ContainerInspect(ctx, id)
+		if client.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range json.Mounts {
+			if m.Type == mount.TypeVolume && m.Name != "" {
+				volumes = append(volumes, callerVolume{Container: json.ID, MountPoint: m})
+			}
+		}
+	}
+	return volumes, nil
+}
+
+/**
+ Translate a bind mount into the caller's named volume its source lives in. Only a whole volume can be mounted: a
+ host path under the volume mountpoint would be resolved by daemon when container starts, so a sidecar could swap a
+ directory for a symlink out of the volume once checked. Daemon resolves a volume subpath safely, but it needs API
+ 1.45, which our client can't send. So a subdirectory bound on the same path, as `-v $WORKSPACE:$WORKSPACE`, gets the
+ whole volume mounted where caller has it, for the path to resolve just the same.
+ */
+func (p *Proxy) workspaceMount(volumes []callerVolume, source string, target string) (mount.Mount, error) {
+	source = path.Clean(source)
+	var volume *callerVolume
+	for i, v := range volumes {
+		// most nested mount wins
+		if volume != nil && len(v.Destination) <= len(volume.Destination) {
+			continue
+		}
+		if underPath(source, path.Clean(v.Destination)) {
+			volume = &volumes[i]
+		}
+	}
+	if volume == nil {
+		return mount.Mount{}, denied("bind mount are not authorized, %s is not in a volume mounted by caller", source)
+	}
+	m := mount.Mount{Type: mount.TypeVolume, Source: volume.Name, Target: target, ReadOnly: !volume.RW}
+	if source != path.Clean(volume.Destination) {
+		if path.Clean(target) != source {
+			return mount.Mount{}, denied("bind mount of %s on %s is not authorized, a subdirectory of volume %s mounted on %s can only be shared on the same path", source, target, volume.Name, volume.Destination)
+		}
+		m.Target = volume.Destination
+	}
+	return m, nil
+}
+
+/**
+ Translate a `/path:/target[:mode]` bind into the caller's volume it lives in
+ */
+func (p *Proxy) workspaceBind(volumes []callerVolume, bind string) (string, error) {
+	source, target, options, err := parseBind(bind)
+	if err != nil {
+		return "", err
+	}
+	m, err := p.workspaceMount(volumes, source, target)
+	if err != nil {
+		return "", err
+	}
+	return formatBind(m.Source, m.Target, options, m.ReadOnly, bindModes), nil
+}
+
+/**
+ Tell if a volume is already mounted at the same target, as subdirectories of a caller volume share the whole volume
+ */
+func sharesMount(mounts []mount.Mount, m mount.Mount) bool {
+	for _, o := range mounts {
+		if o.Type == m.Type && o.Source == m.Source && o.Target == m.Target && o.ReadOnly == m.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
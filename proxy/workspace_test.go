@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/net/context"
+)
+
+/**
+ Start a root container, as a Jenkins agent with its workspace volume, and return its ID
+ */
+func (tp *testProxy) agent(binds ...string) string {
+	tp.t.Helper()
+	tp.proxy.ExpectRoot()
+	return tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": binds},
+	}, "")
+}
+
+/**
+ Mounts of a container, as seen by daemon
+ */
+func (tp *testProxy) mounts(id string) []types.MountPoint {
+	tp.t.Helper()
+	json, err := tp.daemon.ContainerInspect(context.Background(), id)
+	if err != nil {
+		tp.t.Fatal(err)
+	}
+	return json.Mounts
+}
+
+func TestWorkspaceIsBoundFromCallerVolume(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.agent("agent:/home/jenkins", "cache:/cache:ro")
+
+	id := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/home/jenkins:/home/jenkins:rw,z"}},
+	}, "")
+	m := tp.mounts(id)
+	if len(m) != 1 || m[0].Type != mount.TypeVolume || m[0].Name != "agent" || m[0].Destination != "/home/jenkins" || !m[0].RW {
+		t.Fatalf("expected agent volume to be mounted, got %v", m)
+	}
+
+	// whole volume, which caller only has read-only
+	id = tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/cache/:/cache:rw"}},
+	}, "")
+	m = tp.mounts(id)
+	if len(m) != 1 || m[0].Type != mount.TypeVolume || m[0].Name != "cache" || m[0].RW {
+		t.Fatalf("expected cache volume to be mounted read-only, got %v", m)
+	}
+
+	id = tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{"Mounts": []map[string]interface{}{{
+			"Type": "bind", "Source": "/home/jenkins", "Target": "/ws",
+			"BindOptions": map[string]interface{}{"Propagation": "rshared"},
+		}}},
+	}, "")
+	m = tp.mounts(id)
+	if len(m) != 1 || m[0].Type != mount.TypeVolume || m[0].Name != "agent" || m[0].Destination != "/ws" {
+		t.Fatalf("expected agent volume to be mounted, got %v", m)
+	}
+}
+
+func TestHostPathsOutsideCallerVolumesAreDenied(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	agent := tp.agent("agent:/home/jenkins")
+
+	for _, bind := range []string{"/etc:/etc", "/home:/home", "/home/jenkins/../../etc:/etc", "/home/jenkinsfoo:/foo"} {
+		tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+			"Image":      "busybox",
+			"HostConfig": map[string]interface{}{"Binds": []string{bind}},
+		}), http.StatusForbidden, "bind mount are not authorized")
+	}
+
+	// host path of a subdirectory is resolved by daemon on start, when a symlink could have replaced it
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "workspace/job/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.Close()
+	if err := tp.daemon.CopyToContainer(context.Background(), agent, "/home/jenkins", buf, types.CopyToContainerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/home/jenkins/workspace/job:/ws"}},
+	}), http.StatusForbidden, "a subdirectory of volume agent mounted on /home/jenkins can only be shared on the same path")
+}
+
+func TestWorkspaceSubdirectoryMountsWholeVolume(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	tp.agent("agent:/home/jenkins")
+
+	// as docker.inside does, with -v $WORKSPACE:$WORKSPACE
+	id := tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{
+			"/home/jenkins/workspace/job:/home/jenkins/workspace/job:rw,z",
+			"/home/jenkins/workspace/job@tmp:/home/jenkins/workspace/job@tmp:rw,z",
+		}},
+	}, "")
+	m := tp.mounts(id)
+	if len(m) != 1 || m[0].Type != mount.TypeVolume || m[0].Name != "agent" || m[0].Destination != "/home/jenkins" || !m[0].RW {
+		t.Fatalf("expected agent volume to be mounted where agent has it, got %v", m)
+	}
+
+	id = tp.create(map[string]interface{}{
+		"Image": "busybox",
+		"HostConfig": map[string]interface{}{"Mounts": []map[string]interface{}{{
+			"Type": "bind", "Source": "/home/jenkins/workspace/job", "Target": "/home/jenkins/workspace/job",
+		}}},
+	}, "")
+	m = tp.mounts(id)
+	if len(m) != 1 || m[0].Name != "agent" || m[0].Destination != "/home/jenkins" {
+		t.Fatalf("expected agent volume to be mounted where agent has it, got %v", m)
+	}
+}
+
+func TestWorkspaceInPod(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+	ctx := context.Background()
+	if _, err := tp.daemon.ImagePull(ctx, "busybox", types.ImagePullOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	self, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: "busybox"}, &container.HostConfig{Binds: []string{"workspace:/home/jenkins"}}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pause, err := tp.daemon.ContainerCreate(ctx, &container.Config{Image: "busybox"}, &container.HostConfig{Binds: []string{"other:/home/jenkins"}}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cgroup := CgroupOverride(testCgroup)
+	cgroup.ContainerID = self.ID
+	tp.proxy.SetCgroup(cgroup)
+	tp.proxy.SetPod(&Pod{UID: "uid", Name: "agent", Sandbox: pause.ID})
+
+	// volumes are the ones of Lancelot container, not pod sandbox
+	id := tp.create(map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/home/jenkins:/home/jenkins"}},
+	}, "")
+	if m := tp.mounts(id); len(m) != 1 || m[0].Name != "workspace" {
+		t.Fatalf("expected Lancelot container volume to be mounted, got %v", m)
+	}
+}
+
+func TestBindMountsWithoutCaller(t *testing.T) {
+	tp := newTestProxy(t, Policy{})
+	defer tp.Close()
+
+	tp.expectError(tp.do("POST", "/containers/create", map[string]interface{}{
+		"Image":      "busybox",
+		"HostConfig": map[string]interface{}{"Binds": []string{"/home/jenkins:/home/jenkins"}},
+	}), http.StatusForbidden, "bind mount are not authorized")
+}